@@ -0,0 +1,41 @@
+package gopwsh
+
+import (
+	"regexp"
+)
+
+// StripANSI removes ANSI escape sequences, eg: colours, from all output as
+// it is read, including what is mirrored by Tee.
+//
+// This works with every version of PowerShell & any native command but
+// PlainTextOutput is cleaner, if you can rely on PowerShell 7.2 or later.
+func StripANSI(v bool) func(*Shell) error {
+	return func(s *Shell) error {
+		s.stripANSI = v
+		return nil
+	}
+}
+
+// PlainTextOutput sets $PSStyle.OutputRendering = 'PlainText' as soon as the
+// PowerShell process has started, so that PowerShell itself never writes any
+// ANSI escape sequences. Versions of PowerShell older than 7.2 have no
+// $PSStyle & ignore this, see StripANSI.
+func PlainTextOutput() func(*Shell) error {
+	return func(s *Shell) error {
+		s.preferences = append(s.preferences, "if ($PSStyle) { $PSStyle.OutputRendering = 'PlainText' }")
+		return nil
+	}
+}
+
+// ansiEscape matches CSI sequences, eg: colours & cursor movement, OSC
+// sequences, eg: window titles & hyperlinks, & the remaining two character
+// escape sequences.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// stripANSI wraps a callback so that ANSI escape sequences are removed from
+// each line before it is passed on.
+func stripANSI(fn func(string)) func(string) {
+	return func(line string) {
+		fn(ansiEscape.ReplaceAllString(line, ""))
+	}
+}
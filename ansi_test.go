@@ -0,0 +1,70 @@
+package gopwsh
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/brad-jones/gopwsh/backend"
+)
+
+func TestStripANSI(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "hello\n", "hello\n"},
+		{"colour", "\x1b[31mred\x1b[0m\n", "red\n"},
+		{"bold & 256 colours", "\x1b[1;38;5;208mbold\x1b[m\n", "bold\n"},
+		{"cursor movement", "\x1b[2K\x1b[1Gprogress\n", "progress\n"},
+		{"window title", "\x1b]0;title\x07text\n", "text\n"},
+		{"hyperlink", "\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\\n", "link\n"},
+		{"two characters", "\x1bMup\n", "up\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Standing in for eg: Write-Host -ForegroundColor Red
+			b := backend.NewMock().Expect("Write-Host", tt.in, tt.in)
+			var teeOut, teeErr bytes.Buffer
+			s := newMockShell(t, b, StripANSI(true), Tee(&teeOut, &teeErr))
+
+			stdout, stderr, err := s.Execute("Write-Host")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, got := range map[string]string{"stdout": stdout, "stderr": stderr, "teed stdout": teeOut.String(), "teed stderr": teeErr.String()} {
+				if got != tt.want {
+					t.Errorf("got %s %q, want %q", name, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStripANSIDisabled(t *testing.T) {
+	const colour = "\x1b[31mred\x1b[0m\n"
+
+	b := backend.NewMock().Expect("Write-Host", colour, "")
+	s := newMockShell(t, b)
+
+	stdout, _, err := s.Execute("Write-Host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != colour {
+		t.Errorf("got stdout %q, want %q", stdout, colour)
+	}
+}
+
+func TestPlainTextOutput(t *testing.T) {
+	b := backend.NewMock()
+	newMockShell(t, b, PlainTextOutput())
+
+	for _, cmd := range b.Commands() {
+		if cmd == "if ($PSStyle) { $PSStyle.OutputRendering = 'PlainText' }" {
+			return
+		}
+	}
+	t.Errorf("PlainText OutputRendering was not set, got commands %q", b.Commands())
+}
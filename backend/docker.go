@@ -0,0 +1,180 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brad-jones/goerr/v2"
+	"github.com/thanhpk/randstr"
+)
+
+// Docker starts PowerShell inside an already running container, using the
+// docker cli, ie: "docker exec -i".
+//
+// Killing the docker cli leaves the process inside the container running, so
+// Kill also kills it, along with anything it started. That needs a POSIX shell
+// inside the container, in a Windows container only the docker cli is killed
+// & PowerShell keeps running until it's STDIN is closed.
+//
+// Create new instances of this with the "NewDocker()" function.
+type Docker struct {
+	container   string
+	env         map[string]string
+	envCombined bool
+	killID      string
+	local       *Local
+	wd          string
+}
+
+// NewDocker is a constructor like function for the Docker backend.
+//
+// e.g:
+//
+//	b := backend.NewDocker("my-container")
+//	shell, err := gopwsh.New(gopwsh.Backend(b))
+func NewDocker(containerID string) *Docker {
+	return &Docker{
+		container:   containerID,
+		envCombined: true,
+		local:       &Local{},
+	}
+}
+
+func (b *Docker) LookPath(file string) (string, error) {
+	docker, err := b.local.LookPath("docker")
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to locate the docker cli")
+	}
+
+	// "which" exits non-zero without writing anything to STDERR when nothing
+	// is found, docker itself always explains why it failed.
+	out, err := exec.Command(docker, "exec", b.container, "which", file).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) == 0 {
+		return "", goerr.Wrap(ErrNotFound, "failed to find executable in container", b.container, file)
+	}
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to find executable in container", b.container, file)
+	}
+
+	path := strings.TrimSpace(string(out))
+	if path == "" {
+		return "", goerr.Wrap(ErrNotFound, "failed to find executable in container", b.container, file)
+	}
+	return path, nil
+}
+
+func (b *Docker) SetEnv(values map[string]string, combined bool) {
+	b.env = values
+	b.envCombined = combined
+}
+
+func (b *Docker) SetWorkingDir(v string) {
+	b.wd = v
+}
+
+func (b *Docker) StartProcess(cmd string, args ...string) (err error) {
+	defer goerr.Handle(func(e error) { err = e })
+
+	docker, err := b.local.LookPath("docker")
+	goerr.Check(err, "failed to locate the docker cli")
+
+	b.killID = randstr.Hex(12)
+	goerr.Check(b.local.StartProcess(docker, b.execArgs(cmd, args...)...))
+	return
+}
+
+// execArgs builds the arguments given to "docker exec".
+//
+// "docker exec" always combines any variables it is given with the
+// container's environment so when envCombined is false we use "env -i"
+// inside the container to start with a clean slate.
+func (b *Docker) execArgs(cmd string, args ...string) []string {
+	execArgs := []string{"exec", "-i"}
+
+	if b.wd != "" {
+		execArgs = append(execArgs, "-w", b.wd)
+	}
+
+	keys := make([]string, 0, len(b.env))
+	for k := range b.env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// Marks the process, & it's children, so that Kill can find them
+	killVar := dockerKillVar + "=" + b.killID
+
+	if b.envCombined {
+		for _, k := range keys {
+			execArgs = append(execArgs, "-e", k+"="+b.env[k])
+		}
+		execArgs = append(execArgs, "-e", killVar, b.container, cmd)
+	} else {
+		execArgs = append(execArgs, b.container, "env", "-i")
+		for _, k := range keys {
+			execArgs = append(execArgs, k+"="+b.env[k])
+		}
+		execArgs = append(execArgs, killVar, cmd)
+	}
+
+	return append(execArgs, args...)
+}
+
+func (b *Docker) Stderr() io.Reader {
+	return b.local.Stderr()
+}
+
+func (b *Docker) Stdin() io.Writer {
+	return b.local.Stdin()
+}
+
+func (b *Docker) Stdout() io.Reader {
+	return b.local.Stdout()
+}
+
+// LineEnding is "\n" as most containers are Linux containers, use the
+// gopwsh.LineEnding option for Windows containers.
+func (b *Docker) LineEnding() string {
+	return "\n"
+}
+
+func (b *Docker) Wait() error {
+	return b.local.Wait()
+}
+
+func (b *Docker) WaitTimeout(d time.Duration) error {
+	return b.local.WaitTimeout(d)
+}
+
+// Kill kills every process inside the container that was started by
+// StartProcess, if it can, & then the docker cli.
+func (b *Docker) Kill() error {
+	if b.killID != "" {
+		if docker, err := b.local.LookPath("docker"); err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), dockerKillTimeout)
+			defer cancel()
+			// Fails in a container without a POSIX shell, the docker cli is still killed
+			exec.CommandContext(ctx, docker, "exec", b.container,
+				"sh", "-c", dockerKillScript, "sh", dockerKillVar+"="+b.killID,
+			).Run()
+		}
+	}
+	return b.local.Kill()
+}
+
+const (
+	// dockerKillVar is set in the environment of the process started inside
+	// the container, the value is unique to each StartProcess.
+	dockerKillVar = "GOPWSH_KILL_ID"
+
+	// dockerKillScript kills every process whose environment contains $1.
+	dockerKillScript = `for p in /proc/[0-9]*; do ` +
+		`tr '\000' '\n' 2>/dev/null < "$p/environ" | grep -qx "$1" && kill -9 "${p#/proc/}" 2>/dev/null; ` +
+		`done; true`
+
+	dockerKillTimeout = 10 * time.Second
+)
@@ -0,0 +1,75 @@
+package backend
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestDockerExecArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		combined bool
+		wd       string
+		want     []string
+	}{
+		{"default", nil, true, "", []string{"exec", "-i", "-e", "GOPWSH_KILL_ID=abc", "ctr", "pwsh", "-NoExit"}},
+		{"env & wd", map[string]string{"B": "2", "A": "1"}, true, "/tmp", []string{"exec", "-i", "-w", "/tmp", "-e", "A=1", "-e", "B=2", "-e", "GOPWSH_KILL_ID=abc", "ctr", "pwsh", "-NoExit"}},
+		{"clean env", map[string]string{"A": "1"}, false, "", []string{"exec", "-i", "ctr", "env", "-i", "A=1", "GOPWSH_KILL_ID=abc", "pwsh", "-NoExit"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewDocker("ctr")
+			b.SetEnv(tt.env, tt.combined)
+			b.SetWorkingDir(tt.wd)
+			b.killID = "abc"
+			if got := b.execArgs("pwsh", "-NoExit"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDockerKillScript(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs /proc")
+	}
+
+	start := func(killVar string) *exec.Cmd {
+		c := exec.Command("sleep", "60")
+		c.Env = append(os.Environ(), killVar)
+		if err := c.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Process.Kill() })
+		return c
+	}
+	marked := start(dockerKillVar + "=abc")
+	other := start(dockerKillVar + "=abcd")
+
+	if out, err := exec.Command("sh", "-c", dockerKillScript, "sh", dockerKillVar+"=abc").CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	wait := func(c *exec.Cmd, d time.Duration) bool {
+		exited := make(chan error, 1)
+		go func() { exited <- c.Wait() }()
+		select {
+		case <-exited:
+			return true
+		case <-time.After(d):
+			return false
+		}
+	}
+	if !wait(marked, 5*time.Second) {
+		t.Error("the marked process was not killed")
+	}
+	if wait(other, 100*time.Millisecond) {
+		t.Error("a process with a different kill id was killed")
+	}
+}
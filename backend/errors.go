@@ -0,0 +1,14 @@
+package backend
+
+import "errors"
+
+// ErrNotFound is returned, wrapped, by the LookPath method of every backend
+// when the executable does not exist, test for it with errors.Is.
+//
+// Other failures, eg: being unable to connect to a remote host, are not
+// wrapped with ErrNotFound.
+var ErrNotFound = errors.New("executable not found")
+
+// ErrWaitTimeout is returned, wrapped, by the WaitTimeout method of a backend
+// when the process has not exited in time, test for it with errors.Is.
+var ErrWaitTimeout = errors.New("timed out waiting for the process to exit")
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package backend
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Interrupt sends SIGINT to the PowerShell process, which stops the command
+// that is currently executing, just like pressing Ctrl+C.
+func (b *Local) Interrupt() error {
+	p := b.Process()
+	if p == nil {
+		return nil
+	}
+	return p.Signal(os.Interrupt)
+}
+
+// newProcessGroup is only needed on Windows.
+func newProcessGroup(c *exec.Cmd) error {
+	return nil
+}
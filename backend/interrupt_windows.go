@@ -0,0 +1,41 @@
+//go:build windows
+// +build windows
+
+package backend
+
+import (
+	"os/exec"
+	"syscall"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+var generateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+// Interrupt sends a Ctrl+Break event to the PowerShell process, which stops
+// the command that is currently executing.
+//
+// Windows can only send Ctrl+C to every process attached to a console, so
+// PowerShell is started in it's own process group & sent Ctrl+Break instead.
+// This only works when the calling process is attached to a console.
+func (b *Local) Interrupt() error {
+	p := b.Process()
+	if p == nil {
+		return nil
+	}
+	r, _, err := generateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(p.Pid))
+	if r == 0 {
+		return goerr.Wrap(err, "GenerateConsoleCtrlEvent failed")
+	}
+	return nil
+}
+
+// newProcessGroup starts the process in a new process group so that it can be
+// sent a Ctrl+Break event on it's own, see Interrupt.
+func newProcessGroup(c *exec.Cmd) error {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+	return nil
+}
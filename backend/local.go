@@ -1,8 +1,14 @@
 package backend
 
 import (
+	"errors"
 	"io"
+	"os"
 	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/brad-jones/goerr/v2"
 	"github.com/brad-jones/goexec/v2"
@@ -10,51 +16,116 @@ import (
 
 type Local struct {
 	command    *exec.Cmd
-	decorators []func(*exec.Cmd) error
+	env        func(*exec.Cmd) error
+	envExclude []string
+	runAsPass  string
+	runAsUser  string
 	stderr     io.ReadCloser
 	stdin      io.WriteCloser
 	stdout     io.ReadCloser
+	wd         string
 	exited     bool
-}
-
-func (b *Local) init() {
-	if b.decorators == nil {
-		b.decorators = []func(*exec.Cmd) error{}
-	}
+	waitDone   chan struct{}
+	waitErr    error
+	waitMu     sync.Mutex
 }
 
 func (b *Local) LookPath(file string) (string, error) {
-	return exec.LookPath(file)
+	path, err := exec.LookPath(file)
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", goerr.Wrap(ErrNotFound, err.Error())
+	}
+	return path, err
 }
 
+// SetEnv replaces the values given to any previous call, they are applied
+// each time the process is started.
 func (b *Local) SetEnv(values map[string]string, combined bool) {
-	b.init()
 	if values == nil {
 		values = map[string]string{}
 	}
-	e := goexec.Env(values)
+	b.env = goexec.Env(values)
 	if combined {
-		e = goexec.EnvCombined(values)
+		b.env = b.envCombined(values)
 	}
-	b.decorators = append(b.decorators, e)
 }
 
-func (b *Local) SetWorkingDir(v string) {
-	b.init()
-	if v != "" {
-		b.decorators = append(b.decorators, goexec.Cwd(v))
+// ExcludeEnv removes the given variables from the parent's environment when
+// it is combined with the values given to SetEnv. Values given to SetEnv are
+// never removed. Names are matched case insensitively on Windows.
+func (b *Local) ExcludeEnv(keys ...string) {
+	b.envExclude = keys
+}
+
+// envCombined is the same as goexec.EnvCombined but honours ExcludeEnv.
+func (b *Local) envCombined(values map[string]string) func(*exec.Cmd) error {
+	return func(c *exec.Cmd) error {
+		e := []string{}
+		for _, kv := range os.Environ() {
+			if !b.excluded(strings.SplitN(kv, "=", 2)[0]) {
+				e = append(e, kv)
+			}
+		}
+		for k, v := range values {
+			e = append(e, k+"="+v)
+		}
+		c.Env = e
+		return nil
+	}
+}
+
+func (b *Local) excluded(key string) bool {
+	for _, k := range b.envExclude {
+		if k == key || (runtime.GOOS == "windows" && strings.EqualFold(k, key)) {
+			return true
+		}
 	}
+	return false
+}
+
+// RunAs starts the process as another user, logged on with the given
+// credentials, instead of as the current user. It is only supported on
+// Windows, StartProcess returns an error elsewhere.
+//
+// The process is started with CreateProcessAsUser, which requires the caller
+// to hold the "Replace a process level token" privilege, eg: a service running
+// as LocalSystem. The user must be allowed to log on locally.
+//
+// The password is kept in memory so that the process can be started again.
+func (b *Local) RunAs(username, password string) {
+	b.runAsUser = username
+	b.runAsPass = password
+}
+
+func (b *Local) SetWorkingDir(v string) {
+	b.wd = v
 }
 
 func (b *Local) StartProcess(cmd string, args ...string) (err error) {
 	defer goerr.Handle(func(e error) { err = e })
 
-	b.init()
-	b.decorators = append(b.decorators, goexec.Args(args...))
-	c, err := goexec.Cmd(cmd, b.decorators...)
+	decorators := []func(*exec.Cmd) error{}
+	if b.env != nil {
+		decorators = append(decorators, b.env)
+	}
+	if b.wd != "" {
+		decorators = append(decorators, goexec.Cwd(b.wd))
+	}
+	decorators = append(decorators, goexec.Args(args...), newProcessGroup)
+	c, err := goexec.Cmd(cmd, decorators...)
 	goerr.Check(err, "failed to create exec.Cmd")
 
+	if b.runAsUser != "" {
+		release, err := runAs(c, b.runAsUser, b.runAsPass)
+		goerr.Check(err, "failed to log on as", b.runAsUser)
+		defer release()
+	}
+
 	b.command = c
+	b.waitMu.Lock()
+	b.exited = false
+	b.waitDone = nil
+	b.waitMu.Unlock()
 	b.command.Stdin = nil
 	b.command.Stdout = nil
 	b.command.Stderr = nil
@@ -87,6 +158,88 @@ func (b *Local) Stdout() io.Reader {
 	return b.stdout
 }
 
+// LineEnding is the line ending of the OS we are running on.
+func (b *Local) LineEnding() string {
+	if runtime.GOOS == "windows" {
+		return "\r\n"
+	}
+	return "\n"
+}
+
 func (b *Local) Wait() error {
-	return b.command.Wait()
+	<-b.wait()
+	return b.waitError()
+}
+
+// WaitTimeout is the same as Wait but gives up after d, returning an error
+// that wraps ErrWaitTimeout. The process is still waited for in the
+// background so Wait, or WaitTimeout, can be called again, eg: after Kill.
+func (b *Local) WaitTimeout(d time.Duration) error {
+	select {
+	case <-b.wait():
+		return b.waitError()
+	case <-time.After(d):
+		return goerr.Wrap(ErrWaitTimeout, d.String())
+	}
+}
+
+// wait starts waiting for the process, only once, & returns a channel that is
+// closed once it has exited.
+func (b *Local) wait() chan struct{} {
+	b.waitMu.Lock()
+	defer b.waitMu.Unlock()
+
+	if b.waitDone == nil {
+		done := make(chan struct{})
+		b.waitDone = done
+		command := b.command
+		go func() {
+			err := command.Wait()
+			b.waitMu.Lock()
+			// The process may have been started again since
+			if b.waitDone == done {
+				b.exited = true
+				b.waitErr = err
+			}
+			b.waitMu.Unlock()
+			close(done)
+		}()
+	}
+	return b.waitDone
+}
+
+func (b *Local) waitError() error {
+	b.waitMu.Lock()
+	defer b.waitMu.Unlock()
+	return b.waitErr
+}
+
+func (b *Local) Kill() error {
+	if b.command == nil || b.command.Process == nil {
+		return nil
+	}
+	return b.command.Process.Kill()
+}
+
+// Process returns the underlying PowerShell process, this can be used to send
+// custom signals for example. Returns nil if the process has not been started
+// yet or has already exited.
+func (b *Local) Process() *os.Process {
+	b.waitMu.Lock()
+	defer b.waitMu.Unlock()
+
+	if b.command == nil || b.exited {
+		return nil
+	}
+	return b.command.Process
+}
+
+// PID returns the OS process id of the PowerShell process.
+// Returns 0 if the process has not been started yet or has already exited.
+func (b *Local) PID() int {
+	p := b.Process()
+	if p == nil {
+		return 0
+	}
+	return p.Pid
 }
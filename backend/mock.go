@@ -0,0 +1,290 @@
+package backend
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// Mock is an in-memory Starter that never spawns a real PowerShell process,
+// it is intended for unit testing code that uses gopwsh.
+//
+// Commands are answered with the output registered with Expect & every
+// command received is recorded, see Commands. The Mock understands just
+// enough of the protocol used by gopwsh to answer each command, commands
+// are matched exactly, ie: as they were given to Execute.
+//
+// Create new instances of this with the "NewMock()" function.
+type Mock struct {
+	commands     []string
+	env          map[string]string
+	envCombined  bool
+	expectations map[string]mockResponse
+	exited       chan struct{}
+	mu           sync.Mutex
+	stderr       *io.PipeWriter
+	stderrR      *io.PipeReader
+	stdin        *io.PipeWriter
+	stdout       *io.PipeWriter
+	stdoutR      *io.PipeReader
+	wd           string
+}
+
+type mockResponse struct {
+	stdout string
+	stderr string
+}
+
+// NewMock is a constructor like function for the Mock backend.
+//
+// e.g:
+//
+//	b := backend.NewMock().Expect("Get-Date", "Monday\n", "")
+//	shell, err := gopwsh.New(gopwsh.Backend(b))
+func NewMock() *Mock {
+	return &Mock{
+		envCombined:  true,
+		expectations: map[string]mockResponse{},
+	}
+}
+
+// Expect registers the output for a command. Commands that have not been
+// registered succeed without any output, use Commands to assert that only
+// the expected commands were sent.
+//
+// Keep in mind gopwsh sends some commands of it's own, for example to set the
+// OutputEncoding when it starts.
+func (b *Mock) Expect(cmd, stdout, stderr string) *Mock {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expectations[cmd] = mockResponse{stdout: stdout, stderr: stderr}
+	return b
+}
+
+// Commands returns every command received so far, in order.
+func (b *Mock) Commands() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string{}, b.commands...)
+}
+
+// Env returns the values given to SetEnv.
+func (b *Mock) Env() (values map[string]string, combined bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.env, b.envCombined
+}
+
+// WorkingDir returns the value given to SetWorkingDir.
+func (b *Mock) WorkingDir() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.wd
+}
+
+func (b *Mock) LookPath(file string) (string, error) {
+	return file, nil
+}
+
+func (b *Mock) SetEnv(values map[string]string, combined bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.env = values
+	b.envCombined = combined
+}
+
+func (b *Mock) SetWorkingDir(v string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.wd = v
+}
+
+func (b *Mock) StartProcess(cmd string, args ...string) error {
+	stdinR, stdinW := io.Pipe()
+	b.stdin = stdinW
+	b.stdoutR, b.stdout = io.Pipe()
+	b.stderrR, b.stderr = io.Pipe()
+	b.exited = make(chan struct{})
+
+	go b.serve(stdinR, b.stdout, b.stderr, b.exited)
+	return nil
+}
+
+// mockLiteral matches a string literal created by gopwsh.QuoteArg, which is
+// single quoted unless it contains control characters, see mockUnquote.
+const mockLiteral = `('(?:[^'\x{2018}-\x{201B}]|['\x{2018}-\x{201B}]{2})*'|"(?:[^"` + "`" + `]|` + "`" + `.)*")`
+
+var (
+	// mockCommand matches what follows the command, which is found with
+	// mockFindCommand as a regular expression is far too slow for large ones
+	mockCommand = regexp.MustCompile(`(?s)^\r?\n; if \(\$\?\) \{ \$gopwshStatus = 'Ok' \} \}.*` +
+		`echo \(` + mockLiteral + ` \+ ` + mockLiteral + ` \+ ' ' \+ \$global:LASTEXITCODE \+ ' ' \+ \$gopwshStatus(?: \+ \$gopwshDetail)?\); ` +
+		`\[Console\]::Error\.WriteLine\(` + mockLiteral + ` \+ ` + mockLiteral + `\)\r?\n`)
+
+	mockMarkers = regexp.MustCompile(`echo \(` + mockLiteral + ` \+ ` + mockLiteral + `\); ` +
+		`\[Console\]::Error\.WriteLine\(` + mockLiteral + ` \+ ` + mockLiteral + `\)\r?\n`)
+
+	mockExit = regexp.MustCompile(`^\s*exit\r?\n`)
+)
+
+// mockFindCommand returns the command that gopwsh wrapped in "try {...}" &
+// everything after it, ok is false if the command has not been found.
+func mockFindCommand(pending string) (cmd, rest string, ok bool) {
+	start := strings.Index(pending, "try {")
+	if start == -1 {
+		return "", "", false
+	}
+	start += len("try {")
+	if strings.HasPrefix(pending[start:], "\r") {
+		start++
+	}
+	if !strings.HasPrefix(pending[start:], "\n") {
+		return "", "", false
+	}
+	start++
+
+	end := strings.LastIndex(pending, "\n; if ($?) { $gopwshStatus = 'Ok' } }")
+	if end < start {
+		return "", "", false
+	}
+	cmd = strings.TrimSuffix(pending[start:end], "\r")
+	return cmd, pending[end:], true
+}
+
+// mockUnquote returns the value of a string literal matched by mockLiteral.
+func mockUnquote(literal string) string {
+	body := literal[1 : len(literal)-1]
+	if literal[0] == '\'' {
+		return mockSingleQuotes.Replace(body)
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(body); i++ {
+		switch {
+		case body[i] == '`' && i+1 < len(body):
+			i++
+			if c, ok := mockEscapes[body[i]]; ok {
+				sb.WriteByte(c)
+			} else {
+				sb.WriteByte(body[i])
+			}
+		case strings.HasPrefix(body[i:], "$([char]0x"):
+			end := strings.IndexByte(body[i:], ')')
+			if end == -1 {
+				sb.WriteByte(body[i])
+				continue
+			}
+			r, err := strconv.ParseUint(body[i+len("$([char]0x"):i+end], 16, 32)
+			if err != nil {
+				sb.WriteByte(body[i])
+				continue
+			}
+			sb.WriteRune(rune(r))
+			i += end
+		default:
+			sb.WriteByte(body[i])
+		}
+	}
+	return sb.String()
+}
+
+var mockSingleQuotes = strings.NewReplacer(
+	"''", "'",
+	"\u2018\u2018", "\u2018",
+	"\u2019\u2019", "\u2019",
+	"\u201A\u201A", "\u201A",
+	"\u201B\u201B", "\u201B",
+)
+
+var mockEscapes = map[byte]byte{
+	'0': 0, 'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+}
+
+// serve plays the part of PowerShell, it reads everything that gopwsh
+// writes & answers each command once the markers that follow it arrive.
+func (b *Mock) serve(stdin *io.PipeReader, stdout, stderr *io.PipeWriter, exited chan struct{}) {
+	defer close(exited)
+	defer stdout.Close()
+	defer stderr.Close()
+	defer stdin.Close()
+
+	r := bufio.NewReader(stdin)
+	pending := ""
+
+	for {
+		line, err := r.ReadString('\n')
+		pending = pending + line
+		if err != nil {
+			return
+		}
+
+		// Both end with the STDERR marker, only once a line containing it
+		// arrives is it worth searching everything that is pending
+		if strings.Contains(line, "[Console]::Error.WriteLine(") {
+			if cmd, rest, ok := mockFindCommand(pending); ok {
+				if m := mockCommand.FindStringSubmatch(rest); m != nil {
+					pending = ""
+					resp := b.respond(cmd)
+					io.WriteString(stdout, resp.stdout+mockUnquote(m[1])+mockUnquote(m[2])+" 0 Ok\n")
+					io.WriteString(stderr, resp.stderr+mockUnquote(m[3])+mockUnquote(m[4])+"\n")
+					continue
+				}
+			}
+
+			if m := mockMarkers.FindStringSubmatch(pending); m != nil {
+				pending = ""
+				io.WriteString(stdout, mockUnquote(m[1])+mockUnquote(m[2])+"\n")
+				io.WriteString(stderr, mockUnquote(m[3])+mockUnquote(m[4])+"\n")
+				continue
+			}
+		}
+
+		if mockExit.MatchString(pending) {
+			return
+		}
+
+		if strings.TrimSpace(pending) == "" {
+			pending = ""
+		}
+	}
+}
+
+func (b *Mock) respond(cmd string) mockResponse {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.commands = append(b.commands, cmd)
+	return b.expectations[cmd]
+}
+
+func (b *Mock) Stderr() io.Reader {
+	return b.stderrR
+}
+
+func (b *Mock) Stdin() io.Writer {
+	return b.stdin
+}
+
+func (b *Mock) Stdout() io.Reader {
+	return b.stdoutR
+}
+
+func (b *Mock) Wait() error {
+	if b.exited == nil {
+		return goerr.New("mock process was never started")
+	}
+	<-b.exited
+	return nil
+}
+
+func (b *Mock) Kill() error {
+	if b.stdin != nil {
+		b.stdin.Close()
+	}
+	return nil
+}
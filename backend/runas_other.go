@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package backend
+
+import (
+	"os/exec"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// runAs is only supported on Windows, elsewhere sudo can do the same thing,
+// eg: gopwsh.Elevated() with gopwsh.SudoArgs("-u", "username").
+func runAs(c *exec.Cmd, username, password string) (func(), error) {
+	return nil, goerr.New("RunAs is only supported on Windows, use sudo instead, eg: gopwsh.Elevated() with gopwsh.SudoArgs(\"-u\", username)")
+}
@@ -0,0 +1,78 @@
+//go:build windows
+// +build windows
+
+package backend
+
+import (
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+var logonUserW = syscall.NewLazyDLL("advapi32.dll").NewProc("LogonUserW")
+
+const (
+	logon32LogonInteractive = 2
+	logon32ProviderDefault  = 0
+)
+
+// runAs logs on as the user, with LogonUser, & starts the process with the
+// resulting token. The returned func closes the token once it has started.
+//
+// A username without a domain, eg: "svc-build" rather than "DOMAIN\svc-build"
+// or "svc-build@example.com", is looked up in the local account database.
+func runAs(c *exec.Cmd, username, password string) (func(), error) {
+	domain := "."
+	switch {
+	case strings.Contains(username, `\`):
+		parts := strings.SplitN(username, `\`, 2)
+		domain, username = parts[0], parts[1]
+	case strings.Contains(username, "@"):
+		domain = ""
+	}
+
+	user, err := syscall.UTF16PtrFromString(username)
+	if err != nil {
+		return nil, goerr.Wrap(err, "invalid username")
+	}
+	pass, err := syscall.UTF16FromString(password)
+	if err != nil {
+		return nil, goerr.Wrap(err, "invalid password")
+	}
+	// The password is not kept around any longer than it has to be
+	defer func() {
+		for i := range pass {
+			pass[i] = 0
+		}
+	}()
+
+	// A nil domain means the username is a UPN
+	var dom *uint16
+	if domain != "" {
+		if dom, err = syscall.UTF16PtrFromString(domain); err != nil {
+			return nil, goerr.Wrap(err, "invalid domain")
+		}
+	}
+
+	var token syscall.Token
+	r, _, err := logonUserW.Call(
+		uintptr(unsafe.Pointer(user)),
+		uintptr(unsafe.Pointer(dom)),
+		uintptr(unsafe.Pointer(&pass[0])),
+		logon32LogonInteractive,
+		logon32ProviderDefault,
+		uintptr(unsafe.Pointer(&token)),
+	)
+	if r == 0 {
+		return nil, goerr.Wrap(err, "LogonUser failed")
+	}
+
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Token = token
+	return func() { token.Close() }, nil
+}
@@ -0,0 +1,315 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brad-jones/goerr/v2"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSH starts PowerShell on a remote host over an SSH connection.
+//
+// The remote host is expected to provide a POSIX shell, commands are
+// started like: cd '/wd' && exec env FOO='bar' '/usr/bin/pwsh' '-NoExit' ...
+//
+// A new connection is made every time the process is started, so when used
+// with gopwsh.AutoRestart a dropped connection is re-established. See
+// KeepAlive for noticing connections that drop without being closed.
+//
+// Create new instances of this with the "NewSSH()" function.
+type SSH struct {
+	addr          string
+	client        *ssh.Client
+	config        *ssh.ClientConfig
+	env           map[string]string
+	envCombined   bool
+	keepAlive     time.Duration
+	mu            sync.Mutex // guards client, session & stopKeepAlive, which are torn down concurrently
+	session       *ssh.Session
+	stderr        io.Reader
+	stdin         io.WriteCloser
+	stdout        io.Reader
+	stopKeepAlive chan struct{}
+	wd            string
+}
+
+// NewSSH is a constructor like function for the SSH backend.
+//
+// No connection is made until the backend is first used by gopwsh.New().
+//
+// e.g:
+//
+//	b := backend.NewSSH("example.com", 22, &ssh.ClientConfig{...})
+//	shell, err := gopwsh.New(gopwsh.Backend(b))
+func NewSSH(host string, port int, config *ssh.ClientConfig) *SSH {
+	return &SSH{
+		addr:        net.JoinHostPort(host, strconv.Itoa(port)),
+		config:      config,
+		envCombined: true,
+	}
+}
+
+// KeepAlive sends a keepalive request every interval, once connected, & closes
+// the connection when the server has not replied within the next interval.
+//
+// A connection that silently drops, eg: a NAT timeout, would otherwise leave
+// the Shell waiting for output forever. Once closed the Shell sees that the
+// process has died & with gopwsh.AutoRestart a new connection is made.
+//
+// e.g:
+//
+//	b := backend.NewSSH("example.com", 22, &ssh.ClientConfig{...}).KeepAlive(30 * time.Second)
+func (b *SSH) KeepAlive(interval time.Duration) *SSH {
+	b.keepAlive = interval
+	return b
+}
+
+// connect returns the current connection, making a new one if needed.
+func (b *SSH) connect() (*ssh.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.client != nil {
+		return b.client, nil
+	}
+	client, err := ssh.Dial("tcp", b.addr, b.config)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to connect to", b.addr)
+	}
+	b.client = client
+	if b.keepAlive > 0 {
+		b.stopKeepAlive = make(chan struct{})
+		go b.runKeepAlive(client, b.stopKeepAlive)
+	}
+	return client, nil
+}
+
+// runKeepAlive disconnects the client when a keepalive request fails or is
+// not replied to in time. It returns once stop is closed by disconnect.
+//
+// Servers reply to the unknown "keepalive@openssh.com" request with a failure,
+// which is still a reply, so only an error means the connection is gone.
+func (b *SSH) runKeepAlive(client *ssh.Client, stop chan struct{}) {
+	ticker := time.NewTicker(b.keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+
+		select {
+		case err := <-reply:
+			if err == nil {
+				continue
+			}
+		case <-time.After(b.keepAlive):
+		case <-stop:
+			return
+		}
+
+		b.dropped(client)
+		return
+	}
+}
+
+// disconnect closes the connection, a new one will be made if the process is
+// started again.
+func (b *SSH) disconnect() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closeClient()
+}
+
+// dropped disconnects client on behalf of the keepalive, unless a newer
+// connection has already replaced it.
+func (b *SSH) dropped(client *ssh.Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.client == client {
+		b.closeClient()
+	}
+}
+
+// closeClient must be called with mu held.
+func (b *SSH) closeClient() error {
+	if b.client == nil {
+		return nil
+	}
+	if b.stopKeepAlive != nil {
+		close(b.stopKeepAlive)
+		b.stopKeepAlive = nil
+	}
+	err := b.client.Close()
+	b.client = nil
+	return err
+}
+
+// currentSession returns the session of the last started process, if any.
+func (b *SSH) currentSession() *ssh.Session {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.session
+}
+
+func (b *SSH) LookPath(file string) (path string, err error) {
+	defer goerr.Handle(func(e error) { err = e })
+
+	client, err := b.connect()
+	goerr.Check(err)
+
+	session, err := client.NewSession()
+	goerr.Check(err, "failed to open ssh session")
+	defer session.Close()
+
+	// "command -v" exits non-zero when nothing is found
+	out, err := session.Output("command -v " + posixQuote(file))
+	if _, ok := err.(*ssh.ExitError); ok {
+		goerr.Check(ErrNotFound, "failed to find executable on remote host", file)
+	}
+	goerr.Check(err, "failed to find executable on remote host", file)
+
+	path = strings.TrimSpace(string(out))
+	if path == "" {
+		goerr.Check(ErrNotFound, "failed to find executable on remote host", file)
+	}
+	return
+}
+
+func (b *SSH) SetEnv(values map[string]string, combined bool) {
+	b.env = values
+	b.envCombined = combined
+}
+
+func (b *SSH) SetWorkingDir(v string) {
+	b.wd = v
+}
+
+func (b *SSH) StartProcess(cmd string, args ...string) (err error) {
+	defer goerr.Handle(func(e error) { err = e })
+
+	client, err := b.connect()
+	goerr.Check(err)
+
+	session, err := client.NewSession()
+	goerr.Check(err, "failed to open ssh session")
+	b.mu.Lock()
+	b.session = session
+	b.mu.Unlock()
+
+	stdin, err := session.StdinPipe()
+	goerr.Check(err, "Could not get hold of the PowerShell's stdin stream")
+	b.stdin = stdin
+
+	stdout, err := session.StdoutPipe()
+	goerr.Check(err, "Could not get hold of the PowerShell's stdout stream")
+	b.stdout = stdout
+
+	stderr, err := session.StderrPipe()
+	goerr.Check(err, "Could not get hold of the PowerShell's stderr stream")
+	b.stderr = stderr
+
+	goerr.Check(session.Start(b.commandLine(cmd, args...)),
+		"Could not spawn remote PowerShell process",
+	)
+	return
+}
+
+// commandLine builds the string that is handed to the remote POSIX shell.
+// Environment variables are set with "env" as most ssh servers are
+// configured to reject any sent with a "setenv" request.
+func (b *SSH) commandLine(cmd string, args ...string) string {
+	var sb strings.Builder
+
+	if b.wd != "" {
+		sb.WriteString("cd " + posixQuote(b.wd) + " && ")
+	}
+
+	sb.WriteString("exec ")
+
+	if len(b.env) > 0 || !b.envCombined {
+		sb.WriteString("env ")
+		if !b.envCombined {
+			sb.WriteString("-i ")
+		}
+		keys := make([]string, 0, len(b.env))
+		for k := range b.env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sb.WriteString(fmt.Sprintf("%s=%s ", k, posixQuote(b.env[k])))
+		}
+	}
+
+	sb.WriteString(posixQuote(cmd))
+	for _, arg := range args {
+		sb.WriteString(" " + posixQuote(arg))
+	}
+
+	return sb.String()
+}
+
+func (b *SSH) Stderr() io.Reader {
+	return b.stderr
+}
+
+func (b *SSH) Stdin() io.Writer {
+	return b.stdin
+}
+
+func (b *SSH) Stdout() io.Reader {
+	return b.stdout
+}
+
+// LineEnding is always "\n" as the remote host is expected to be POSIX.
+func (b *SSH) LineEnding() string {
+	return "\n"
+}
+
+func (b *SSH) Wait() error {
+	defer b.disconnect()
+	return b.currentSession().Wait()
+}
+
+// Kill asks the remote process to die & then closes the connection.
+// Plenty of ssh servers ignore signal requests but closing the connection
+// will hang up the remote process regardless.
+func (b *SSH) Kill() error {
+	if session := b.currentSession(); session != nil {
+		session.Signal(ssh.SIGKILL)
+	}
+	return b.disconnect()
+}
+
+// Interrupt asks the remote process to stop the command that is currently
+// executing by sending it SIGINT. Plenty of ssh servers ignore signal
+// requests, in which case nothing happens.
+func (b *SSH) Interrupt() error {
+	session := b.currentSession()
+	if session == nil {
+		return nil
+	}
+	return session.Signal(ssh.SIGINT)
+}
+
+// posixQuote escapes a string so that it is treated as a single literal
+// argument by a POSIX shell.
+func posixQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,259 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/brad-jones/goerr/v2"
+	"github.com/masterzen/winrm"
+)
+
+// WinRM starts PowerShell on a remote Windows host over WinRM.
+//
+// A WinRM shell is opened & a long running "powershell -NoExit -Command -"
+// command is started inside it, the command's streams are piped to & from
+// the Shell just like a local process.
+//
+// WinRM can only set environment variables & the working directory when the
+// shell is first opened, which the underlying library does not expose. So
+// instead they are applied by the very first line that is written to the
+// PowerShell process, before any commands are executed. StartProcess returns
+// an error if they could not be applied.
+//
+// Create new instances of this with the "NewWinRM()" function.
+type WinRM struct {
+	client      *winrm.Client
+	command     *winrm.Command
+	env         map[string]string
+	envCombined bool
+	shell       *winrm.Shell
+	wd          string
+}
+
+// NewWinRM is a constructor like function for the WinRM backend.
+//
+// No connection is made until the backend is first used by gopwsh.New().
+//
+// e.g:
+//
+//	endpoint := winrm.NewEndpoint("example.com", 5986, true, false, nil, nil, nil, 0)
+//	client, err := winrm.NewClient(endpoint, "Administrator", "secret")
+//	b := backend.NewWinRM(client)
+//	shell, err := gopwsh.New(gopwsh.Backend(b))
+func NewWinRM(client *winrm.Client) *WinRM {
+	return &WinRM{
+		client:      client,
+		envCombined: true,
+	}
+}
+
+// winrmKnownPaths are returned by LookPath without asking the remote host.
+// Windows PowerShell ships with every supported version of Windows.
+var winrmKnownPaths = map[string]string{
+	"powershell":     `C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`,
+	"powershell.exe": `C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`,
+}
+
+func (b *WinRM) LookPath(file string) (string, error) {
+	if path, ok := winrmKnownPaths[strings.ToLower(file)]; ok {
+		return path, nil
+	}
+
+	quoted, err := cmdQuote(file)
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to find executable on remote host", file)
+	}
+
+	stdout, _, exitCode, err := b.client.RunWithString("where.exe "+quoted, "")
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to find executable on remote host", file)
+	}
+	if exitCode == 1 {
+		// where.exe exits with 1 when nothing is found
+		return "", goerr.Wrap(ErrNotFound, "failed to find executable on remote host", file)
+	}
+	if exitCode != 0 {
+		return "", goerr.Wrap(fmt.Sprintf("failed to find executable on remote host, where.exe exited with %d", exitCode), file)
+	}
+
+	// where.exe lists every match, the first one is the one that would be used
+	path := strings.TrimSpace(strings.SplitN(stdout, "\n", 2)[0])
+	if path == "" {
+		return "", goerr.Wrap(ErrNotFound, "failed to find executable on remote host", file)
+	}
+	return path, nil
+}
+
+func (b *WinRM) SetEnv(values map[string]string, combined bool) {
+	b.env = values
+	b.envCombined = combined
+}
+
+func (b *WinRM) SetWorkingDir(v string) {
+	b.wd = v
+}
+
+func (b *WinRM) StartProcess(cmd string, args ...string) (err error) {
+	defer goerr.Handle(func(e error) { err = e })
+
+	shell, err := b.client.CreateShell()
+	goerr.Check(err, "failed to open winrm shell")
+	b.shell = shell
+
+	command, err := b.shell.Execute(cmd, args...)
+	goerr.Check(err, "Could not spawn remote PowerShell process")
+	b.command = command
+
+	if prelude := b.prelude(); prelude != "" {
+		_, err := b.command.Stdin.Write([]byte(prelude + "\n"))
+		goerr.Check(err, "failed to set the environment of the remote PowerShell process")
+		goerr.Check(b.preludeResult(), "failed to set the environment of the remote PowerShell process")
+	}
+	return
+}
+
+const (
+	winrmPreludeOk     = "gopwsh-prelude-ok"
+	winrmPreludeFailed = "gopwsh-prelude-failed"
+)
+
+// prelude builds the PowerShell statements that apply the environment
+// variables & working directory. They are stopped by the first error, which
+// is written to STDOUT on a single line, see preludeResult.
+func (b *WinRM) prelude() string {
+	statements := []string{}
+
+	if !b.envCombined {
+		statements = append(statements, "Get-ChildItem env: | Remove-Item")
+	}
+
+	keys := make([]string, 0, len(b.env))
+	for k := range b.env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		statements = append(statements,
+			"Set-Item -LiteralPath "+psQuote("env:"+k)+" -Value "+psQuote(b.env[k]),
+		)
+	}
+
+	if b.wd != "" {
+		statements = append(statements, "Set-Location -LiteralPath "+psQuote(b.wd))
+	}
+
+	if len(statements) == 0 {
+		return ""
+	}
+
+	return "$gopwshEAP = $ErrorActionPreference; $ErrorActionPreference = 'Stop'; " +
+		"try { " + strings.Join(statements, "; ") + "; [Console]::Out.WriteLine('" + winrmPreludeOk + "') } " +
+		"catch { [Console]::Out.WriteLine('" + winrmPreludeFailed + " ' + ($_.Exception.Message -replace '\\r?\\n', ' ')) } " +
+		"finally { $ErrorActionPreference = $gopwshEAP; Remove-Variable gopwshEAP }"
+}
+
+// preludeResult reads STDOUT until the prelude reports how it went. It reads
+// a byte at a time so that nothing written after it is taken from gopwsh.
+func (b *WinRM) preludeResult() error {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := b.command.Stdout.Read(buf)
+		if n == 1 && buf[0] != '\n' {
+			line = append(line, buf[0])
+			continue
+		}
+		if n == 1 {
+			result := strings.TrimSpace(string(line))
+			line = line[:0]
+			if result == winrmPreludeOk {
+				return nil
+			}
+			if strings.HasPrefix(result, winrmPreludeFailed) {
+				return goerr.New(strings.TrimSpace(strings.TrimPrefix(result, winrmPreludeFailed)))
+			}
+		}
+		if err != nil {
+			return goerr.Wrap(err, "remote PowerShell process stopped before the prelude completed")
+		}
+	}
+}
+
+func (b *WinRM) Stderr() io.Reader {
+	return b.command.Stderr
+}
+
+func (b *WinRM) Stdin() io.Writer {
+	return b.command.Stdin
+}
+
+func (b *WinRM) Stdout() io.Reader {
+	return b.command.Stdout
+}
+
+// WriteFrame sends a whole command to the remote PowerShell process in a single
+// WinRM Send request, so that it is never split across requests.
+func (b *WinRM) WriteFrame(p []byte) error {
+	n, err := b.command.Stdin.Write(p)
+	if err != nil {
+		return goerr.Wrap(err, "failed to send command to the remote PowerShell process")
+	}
+	if n != len(p) {
+		return goerr.Wrap(io.ErrShortWrite, "failed to send command to the remote PowerShell process")
+	}
+	return nil
+}
+
+// LineEnding is always "\r\n" as WinRM is only found on Windows.
+func (b *WinRM) LineEnding() string {
+	return "\r\n"
+}
+
+func (b *WinRM) Wait() error {
+	defer b.shell.Close()
+	b.command.Wait()
+	if exitCode := b.command.ExitCode(); exitCode != 0 {
+		return goerr.New(fmt.Sprintf("remote PowerShell process exited with %d", exitCode))
+	}
+	return nil
+}
+
+// Kill terminates the remote command & then deletes the WinRM shell.
+func (b *WinRM) Kill() error {
+	if b.command != nil {
+		b.command.Close()
+	}
+	if b.shell != nil {
+		return b.shell.Close()
+	}
+	return nil
+}
+
+// psQuote escapes a string so that it is treated as a single literal
+// argument by PowerShell, see also gopwsh.QuoteArg.
+func psQuote(s string) string {
+	return "'" + psQuoteEscaper.Replace(s) + "'"
+}
+
+// psQuoteEscaper doubles every quote that ends a PowerShell single quoted
+// string, which includes the "smart" single quotes.
+var psQuoteEscaper = strings.NewReplacer(
+	"'", "''",
+	"\u2018", "\u2018\u2018",
+	"\u2019", "\u2019\u2019",
+	"\u201A", "\u201A\u201A",
+	"\u201B", "\u201B\u201B",
+)
+
+// cmdQuote quotes a single argument for cmd.exe, which runs the commands
+// given to RunWithString. cmd.exe has no way to escape a double quote within
+// quotes, nor to stop "%" from expanding variables, so neither is allowed.
+func cmdQuote(s string) (string, error) {
+	if strings.ContainsAny(s, "\"%") || strings.IndexFunc(s, unicode.IsControl) != -1 {
+		return "", goerr.New("can not be quoted for cmd.exe")
+	}
+	return `"` + s + `"`, nil
+}
@@ -0,0 +1,198 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// WSL starts PowerShell inside a distribution of the Windows Subsystem for
+// Linux, from Windows, using wsl.exe, ie: "wsl.exe -d distro --exec pwsh ...".
+//
+// Create new instances of this with the "NewWSL()" function.
+type WSL struct {
+	distro      string
+	env         map[string]string
+	envCombined bool
+	local       *Local
+	wd          string
+	wdErr       error
+}
+
+// NewWSL is a constructor like function for the WSL backend.
+//
+// An empty distro uses the default distribution, see "wsl.exe --list".
+//
+// e.g:
+//
+//	b := backend.NewWSL("Ubuntu")
+//	shell, err := gopwsh.New(gopwsh.Backend(b))
+func NewWSL(distro string) *WSL {
+	return &WSL{
+		distro:      distro,
+		envCombined: true,
+		local:       &Local{},
+	}
+}
+
+func (b *WSL) LookPath(file string) (string, error) {
+	wsl, err := b.local.LookPath("wsl")
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to locate wsl.exe")
+	}
+
+	// "which" exits non-zero without writing anything to STDERR when nothing
+	// is found, wsl.exe itself always explains why it failed.
+	out, err := exec.Command(wsl, append(b.distroArgs(), "--exec", "which", file)...).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) == 0 {
+		return "", goerr.Wrap(ErrNotFound, "failed to find executable in WSL", b.distro, file)
+	}
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to find executable in WSL", b.distro, file)
+	}
+
+	path := strings.TrimSpace(string(out))
+	if path == "" {
+		return "", goerr.Wrap(ErrNotFound, "failed to find executable in WSL", b.distro, file)
+	}
+	return path, nil
+}
+
+func (b *WSL) SetEnv(values map[string]string, combined bool) {
+	b.env = values
+	b.envCombined = combined
+}
+
+// SetWorkingDir sets the working dir inside the distribution, Windows paths
+// are translated to their WSL equivalent, see WSLPath.
+//
+// A path into a different distribution than the one given to NewWSL, eg:
+// "\\wsl$\Debian\home" for "Ubuntu", can't be used & StartProcess returns
+// an error. Any distribution is accepted when using the default distribution.
+func (b *WSL) SetWorkingDir(v string) {
+	path, distro := wslPath(v)
+	b.wd = path
+	b.wdErr = nil
+	if distro != "" && b.distro != "" && !strings.EqualFold(distro, b.distro) {
+		b.wdErr = goerr.New(fmt.Sprintf("the working dir %s is inside the %s distribution, not %s", v, distro, b.distro))
+	}
+}
+
+func (b *WSL) StartProcess(cmd string, args ...string) (err error) {
+	defer goerr.Handle(func(e error) { err = e })
+
+	goerr.Check(b.wdErr)
+
+	wsl, err := b.local.LookPath("wsl")
+	goerr.Check(err, "failed to locate wsl.exe")
+
+	goerr.Check(b.local.StartProcess(wsl, b.execArgs(cmd, args...)...))
+	return
+}
+
+func (b *WSL) distroArgs() []string {
+	if b.distro == "" {
+		return []string{}
+	}
+	return []string{"-d", b.distro}
+}
+
+// execArgs builds the arguments given to wsl.exe.
+//
+// Variables of the Windows environment are not shared with WSL, unless listed
+// in WSLENV, so they are always set with "env" inside the distribution.
+// --exec is used so that no shell inside the distribution interprets the
+// arguments.
+func (b *WSL) execArgs(cmd string, args ...string) []string {
+	execArgs := b.distroArgs()
+
+	if b.wd != "" {
+		execArgs = append(execArgs, "--cd", b.wd)
+	}
+
+	execArgs = append(execArgs, "--exec")
+
+	if len(b.env) > 0 || !b.envCombined {
+		execArgs = append(execArgs, "env")
+		if !b.envCombined {
+			execArgs = append(execArgs, "-i")
+		}
+		keys := make([]string, 0, len(b.env))
+		for k := range b.env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			execArgs = append(execArgs, k+"="+b.env[k])
+		}
+	}
+
+	execArgs = append(execArgs, cmd)
+	return append(execArgs, args...)
+}
+
+func (b *WSL) Stderr() io.Reader {
+	return b.local.Stderr()
+}
+
+func (b *WSL) Stdin() io.Writer {
+	return b.local.Stdin()
+}
+
+func (b *WSL) Stdout() io.Reader {
+	return b.local.Stdout()
+}
+
+// LineEnding is always "\n" as WSL distributions are Linux.
+func (b *WSL) LineEnding() string {
+	return "\n"
+}
+
+func (b *WSL) Wait() error {
+	return b.local.Wait()
+}
+
+func (b *WSL) WaitTimeout(d time.Duration) error {
+	return b.local.WaitTimeout(d)
+}
+
+func (b *WSL) Kill() error {
+	return b.local.Kill()
+}
+
+var (
+	windowsDrivePath = regexp.MustCompile(`^([A-Za-z]):(?:[\\/](.*))?$`)
+	wslUNCPath       = regexp.MustCompile(`(?i)^[\\/]{2}wsl(?:\$|\.localhost)[\\/]([^\\/]+)(?:[\\/](.*))?$`)
+)
+
+// WSLPath translates a Windows path into the path of the same file inside a
+// WSL distribution, eg: "C:\Users\foo" becomes "/mnt/c/Users/foo" & a path
+// into a distribution, eg: "\\wsl$\Ubuntu\home\foo", becomes "/home/foo".
+//
+// Anything else, eg: "/home/foo", is assumed to already be a WSL path & is
+// returned as is. Drives are assumed to be mounted under the default "/mnt/",
+// the same as the "wslpath" command does without a custom wsl.conf.
+//
+// The distribution named by a path into one is ignored, see WSL.SetWorkingDir.
+func WSLPath(v string) string {
+	path, _ := wslPath(v)
+	return path
+}
+
+// wslPath is the same as WSLPath but also returns the distribution that a path
+// into a distribution names.
+func wslPath(v string) (path, distro string) {
+	if m := windowsDrivePath.FindStringSubmatch(v); m != nil {
+		return strings.TrimSuffix("/mnt/"+strings.ToLower(m[1])+"/"+strings.ReplaceAll(m[2], `\`, "/"), "/"), ""
+	}
+	if m := wslUNCPath.FindStringSubmatch(v); m != nil {
+		return "/" + strings.TrimSuffix(strings.ReplaceAll(m[2], `\`, "/"), "/"), m[1]
+	}
+	return v, ""
+}
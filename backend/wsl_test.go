@@ -0,0 +1,75 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWSLPath(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"drive path", `C:\foo`, "/mnt/c/foo"},
+		{"nested drive path", `D:\Users\foo\bar baz`, "/mnt/d/Users/foo/bar baz"},
+		{"bare drive", "C:", "/mnt/c"},
+		{"drive root", `C:\`, "/mnt/c"},
+		{"forward slashes", "C:/foo/bar", "/mnt/c/foo/bar"},
+		{"trailing separator", `c:\foo\`, "/mnt/c/foo"},
+		{"wsl$", `\\wsl$\Ubuntu\home\foo`, "/home/foo"},
+		{"wsl.localhost", `\\wsl.localhost\Ubuntu`, "/"},
+		{"wsl.localhost root", `\\wsl.localhost\Ubuntu\`, "/"},
+		{"forward slash UNC", "//wsl$/Ubuntu/etc", "/etc"},
+		{"upper case UNC", `\\WSL$\Ubuntu\etc`, "/etc"},
+		{"POSIX", "/home/foo", "/home/foo"},
+		{"relative", "foo/bar", "foo/bar"},
+		{"other UNC", `\\server\share\foo`, `\\server\share\foo`},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WSLPath(tt.in); got != tt.want {
+				t.Errorf("WSLPath(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWSLSetWorkingDir(t *testing.T) {
+	tests := []struct {
+		name   string
+		distro string
+		wd     string
+		want   string
+		err    bool
+	}{
+		{"drive path", "Ubuntu", `C:\foo`, "/mnt/c/foo", false},
+		{"same distro", "Ubuntu", `\\wsl$\Ubuntu\home`, "/home", false},
+		{"same distro in any case", "Ubuntu", `\\wsl.localhost\ubuntu\home`, "/home", false},
+		{"other distro", "Ubuntu", `\\wsl$\Debian\home`, "", true},
+		{"default distro", "", `\\wsl$\Debian\home`, "/home", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewWSL(tt.distro)
+			b.SetWorkingDir(tt.wd)
+			if (b.wdErr != nil) != tt.err {
+				t.Fatalf("got error %v, want an error: %v", b.wdErr, tt.err)
+			}
+			if tt.err {
+				if err := b.StartProcess("pwsh"); err == nil {
+					t.Error("StartProcess did not return an error")
+				}
+				return
+			}
+
+			want := append(b.distroArgs(), "--cd", tt.want, "--exec", "pwsh")
+			if got := b.execArgs("pwsh"); !reflect.DeepEqual(got, want) {
+				t.Errorf("got args %q, want %q", got, want)
+			}
+		})
+	}
+}
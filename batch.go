@@ -0,0 +1,52 @@
+package gopwsh
+
+import (
+	"context"
+	"errors"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// CommandResult is the outcome of a single command executed by ExecuteAll.
+type CommandResult struct {
+	Command string
+	Stdout  string
+	Stderr  string
+
+	// Err is the same error Execute would have returned for this command.
+	Err error
+}
+
+// ExecuteAll is like Execute but returns the output of each command
+// separately, rather than concatenating it, so you can see exactly which
+// command in a batch produced what.
+//
+// A command that fails, ie: a RuntimeError, is recorded in it's result &
+// the next command is still executed. Any other error, eg: a ParserError or
+// the PowerShell process dying, means the Shell can't continue, the results
+// so far, including the failed command, are returned along with that error.
+func (s *Shell) ExecuteAll(cmds ...string) ([]CommandResult, error) {
+	results := make([]CommandResult, 0, len(cmds))
+
+	for _, cmd := range cmds {
+		stdout, stderr, err := s.execute(context.Background(), cmd)
+		if err != nil {
+			err = goerr.Wrap(err, "failed to execute", cmd)
+		}
+		results = append(results, CommandResult{Command: cmd, Stdout: stdout, Stderr: stderr, Err: err})
+
+		var runtimeErr *RuntimeError
+		if err != nil && !errors.As(err, &runtimeErr) {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// MustExecuteAll is the same as ExecuteAll but panics on error instead of returning an error.
+func (s *Shell) MustExecuteAll(cmds ...string) []CommandResult {
+	results, err := s.ExecuteAll(cmds...)
+	goerr.Check(err)
+	return results
+}
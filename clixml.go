@@ -0,0 +1,249 @@
+package gopwsh
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// XMLDepth sets the depth given to the PSSerializer by ExecuteXML, objects
+// nested deeper than this are serialized as strings.
+//
+// Defaults to 1, the same depth that PowerShell Remoting uses.
+func XMLDepth(depth int) func(*Shell) error {
+	return func(s *Shell) error {
+		if depth < 1 {
+			return goerr.New(fmt.Sprintf("XMLDepth must be greater than 0, got %d", depth))
+		}
+		s.xmlDepth = depth
+		return nil
+	}
+}
+
+// CliXmlObject is a node of a deserialized CLIXML document, the format used by
+// PowerShell Remoting & Export-Clixml.
+//
+// see: https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-psrp/c8c85974-ffd7-4455-84a8-e49016c20683
+type CliXmlObject struct {
+	// Kind is the name of the element, eg: "Obj" for a complex object, "Nil"
+	// for $null or a primitive type such as "S" (string), "I32", "B" (bool),
+	// "DT" (DateTime), etc.
+	Kind string
+
+	// Name is the name of the property this object is the value of, if any.
+	Name string
+
+	// TypeNames is the type hierarchy of a complex object, most specific first.
+	TypeNames []string
+
+	// ToString is the result of calling ToString() on a complex object.
+	ToString string
+
+	// Value is the text of a primitive, or of the primitive that an enum or
+	// other complex object wraps. Strings have been unescaped.
+	Value string
+
+	// Properties holds both the adapted & extended properties of a complex object.
+	Properties []*CliXmlObject
+
+	// Items holds the members of a list, stack, queue or other enumerable.
+	Items []*CliXmlObject
+
+	// Entries holds the members of a dictionary, eg: a hashtable.
+	Entries []CliXmlEntry
+}
+
+// CliXmlEntry is a single key/value pair of a dictionary.
+type CliXmlEntry struct {
+	Key   *CliXmlObject
+	Value *CliXmlObject
+}
+
+// Property returns the property with the given name, or nil.
+func (o *CliXmlObject) Property(name string) *CliXmlObject {
+	for _, p := range o.Properties {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// ExecuteXML executes a command & serializes it's output to CLIXML, with the
+// PSSerializer, which is then parsed into a tree of CliXmlObjects.
+//
+// CLIXML keeps much more type information than JSON, eg: type names, dates &
+// the difference between an int & a long. The output is always collected into
+// an array so the root object's Items are the objects that were output.
+func (s *Shell) ExecuteXML(cmd string) (*CliXmlObject, error) {
+	// On their own lines so that a trailing comment can't swallow the "}"
+	wrapped := fmt.Sprintf("[System.Management.Automation.PSSerializer]::Serialize(@(. {%[3]s%[1]s%[3]s}), %[2]d)", cmd, s.xmlDepth, s.lineEnding)
+
+	stdout, stderr, err := s.execute(context.Background(), wrapped)
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to execute", cmd)
+	}
+
+	// Skip anything written directly to the host before the document
+	start := strings.Index(stdout, "<Objs")
+	if start == -1 {
+		return nil, goerr.Wrap("no CLIXML found in the output of", cmd, stderr)
+	}
+
+	root, err := parseCliXml(stdout[start:])
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to decode the CLIXML output of", cmd, stderr)
+	}
+	return root, nil
+}
+
+// MustExecuteXML is the same as ExecuteXML but panics on error instead of returning an error.
+func (s *Shell) MustExecuteXML(cmd string) *CliXmlObject {
+	root, err := s.ExecuteXML(cmd)
+	goerr.Check(err)
+	return root
+}
+
+type cliXmlNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr   `xml:",any,attr"`
+	Content  string       `xml:",chardata"`
+	Children []cliXmlNode `xml:",any"`
+}
+
+func (n *cliXmlNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+type cliXmlParser struct {
+	typeNames map[string][]string
+	objects   map[string]*CliXmlObject
+}
+
+func parseCliXml(doc string) (*CliXmlObject, error) {
+	var root cliXmlNode
+	if err := xml.Unmarshal([]byte(doc), &root); err != nil {
+		return nil, err
+	}
+	if len(root.Children) != 1 {
+		return nil, goerr.New(fmt.Sprintf("expected a single root object, got %d", len(root.Children)))
+	}
+
+	p := &cliXmlParser{typeNames: map[string][]string{}, objects: map[string]*CliXmlObject{}}
+	return p.convert(&root.Children[0])
+}
+
+func (p *cliXmlParser) convert(n *cliXmlNode) (*CliXmlObject, error) {
+	kind := n.XMLName.Local
+	name := decodeCliXmlString(n.attr("N"))
+
+	switch kind {
+	case "Ref":
+		ref, ok := p.objects[n.attr("RefId")]
+		if !ok {
+			return nil, goerr.Wrap("unknown object reference", n.attr("RefId"))
+		}
+		o := *ref
+		o.Name = name
+		return &o, nil
+
+	case "Obj":
+		o := &CliXmlObject{Kind: kind, Name: name}
+		if id := n.attr("RefId"); id != "" {
+			p.objects[id] = o
+		}
+		for i := range n.Children {
+			if err := p.convertChild(o, &n.Children[i]); err != nil {
+				return nil, err
+			}
+		}
+		return o, nil
+
+	case "S", "XD", "SBK":
+		return &CliXmlObject{Kind: kind, Name: name, Value: decodeCliXmlString(n.Content)}, nil
+	}
+
+	return &CliXmlObject{Kind: kind, Name: name, Value: n.Content}, nil
+}
+
+func (p *cliXmlParser) convertChild(o *CliXmlObject, c *cliXmlNode) error {
+	switch c.XMLName.Local {
+	case "TN":
+		for _, t := range c.Children {
+			o.TypeNames = append(o.TypeNames, t.Content)
+		}
+		p.typeNames[c.attr("RefId")] = o.TypeNames
+	case "TNRef":
+		o.TypeNames = p.typeNames[c.attr("RefId")]
+	case "ToString":
+		o.ToString = decodeCliXmlString(c.Content)
+	case "Props", "MS":
+		for i := range c.Children {
+			prop, err := p.convert(&c.Children[i])
+			if err != nil {
+				return err
+			}
+			o.Properties = append(o.Properties, prop)
+		}
+	case "LST", "IE", "QUE", "STK":
+		for i := range c.Children {
+			item, err := p.convert(&c.Children[i])
+			if err != nil {
+				return err
+			}
+			o.Items = append(o.Items, item)
+		}
+	case "DCT":
+		for _, en := range c.Children {
+			entry := CliXmlEntry{}
+			for i := range en.Children {
+				v, err := p.convert(&en.Children[i])
+				if err != nil {
+					return err
+				}
+				switch v.Name {
+				case "Key":
+					entry.Key = v
+				case "Value":
+					entry.Value = v
+				}
+			}
+			o.Entries = append(o.Entries, entry)
+		}
+	default:
+		// The primitive value wrapped by an enum or similar
+		v, err := p.convert(c)
+		if err != nil {
+			return err
+		}
+		o.Value = v.Value
+	}
+	return nil
+}
+
+var cliXmlEscape = regexp.MustCompile(`(?:_x[0-9A-Fa-f]{4}_)+`)
+
+// decodeCliXmlString reverses the _xHHHH_ escaping CLIXML uses for characters
+// that XML can't hold, eg: control characters. Consecutive escapes may be a
+// UTF-16 surrogate pair.
+func decodeCliXmlString(s string) string {
+	return cliXmlEscape.ReplaceAllStringFunc(s, func(m string) string {
+		units := []uint16{}
+		for i := 0; i+7 <= len(m); i += 7 {
+			u, _ := strconv.ParseUint(m[i+2:i+6], 16, 16)
+			units = append(units, uint16(u))
+		}
+		return string(utf16.Decode(units))
+	})
+}
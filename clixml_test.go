@@ -0,0 +1,139 @@
+package gopwsh
+
+import (
+	"reflect"
+	"testing"
+)
+
+// cliXmlArray wraps items the same way ExecuteXML's @(...) does.
+func cliXmlArray(items string) string {
+	return `<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04">` +
+		`<Obj RefId="0"><TN RefId="0"><T>System.Object[]</T><T>System.Array</T><T>System.Object</T></TN>` +
+		`<LST>` + items + `</LST></Obj></Objs>`
+}
+
+func TestParseCliXml(t *testing.T) {
+	process := `<Obj RefId="1"><TN RefId="1"><T>System.Diagnostics.Process</T><T>System.Object</T></TN>` +
+		`<ToString>System.Diagnostics.Process (pwsh)</ToString>` +
+		`<Props><S N="Name">pwsh</S><I32 N="Id">42</I32></Props></Obj>`
+
+	tests := []struct {
+		name  string
+		items string
+		want  []*CliXmlObject
+	}{
+		{
+			"primitives",
+			`<S>foo</S><I32>1</I32><I64>2</I64><B>true</B><Nil />`,
+			[]*CliXmlObject{
+				{Kind: "S", Value: "foo"},
+				{Kind: "I32", Value: "1"},
+				{Kind: "I64", Value: "2"},
+				{Kind: "B", Value: "true"},
+				{Kind: "Nil"},
+			},
+		},
+		{
+			"escaped string",
+			`<S>line1_x000A_line2 _xD83C__xDF0D_</S>`,
+			[]*CliXmlObject{{Kind: "S", Value: "line1\nline2 🌍"}},
+		},
+		{
+			"object",
+			process,
+			[]*CliXmlObject{{
+				Kind:      "Obj",
+				TypeNames: []string{"System.Diagnostics.Process", "System.Object"},
+				ToString:  "System.Diagnostics.Process (pwsh)",
+				Properties: []*CliXmlObject{
+					{Kind: "S", Name: "Name", Value: "pwsh"},
+					{Kind: "I32", Name: "Id", Value: "42"},
+				},
+			}},
+		},
+		{
+			"type name & object references",
+			process + `<Obj RefId="2"><TNRef RefId="1" /><ToString>other</ToString></Obj><Ref RefId="1" />`,
+			[]*CliXmlObject{
+				{
+					Kind:      "Obj",
+					TypeNames: []string{"System.Diagnostics.Process", "System.Object"},
+					ToString:  "System.Diagnostics.Process (pwsh)",
+					Properties: []*CliXmlObject{
+						{Kind: "S", Name: "Name", Value: "pwsh"},
+						{Kind: "I32", Name: "Id", Value: "42"},
+					},
+				},
+				{
+					Kind:      "Obj",
+					TypeNames: []string{"System.Diagnostics.Process", "System.Object"},
+					ToString:  "other",
+				},
+				{
+					Kind:      "Obj",
+					TypeNames: []string{"System.Diagnostics.Process", "System.Object"},
+					ToString:  "System.Diagnostics.Process (pwsh)",
+					Properties: []*CliXmlObject{
+						{Kind: "S", Name: "Name", Value: "pwsh"},
+						{Kind: "I32", Name: "Id", Value: "42"},
+					},
+				},
+			},
+		},
+		{
+			"enum",
+			`<Obj RefId="1"><TN RefId="1"><T>System.DayOfWeek</T><T>System.Enum</T></TN><ToString>Monday</ToString><I32>1</I32></Obj>`,
+			[]*CliXmlObject{{
+				Kind:      "Obj",
+				TypeNames: []string{"System.DayOfWeek", "System.Enum"},
+				ToString:  "Monday",
+				Value:     "1",
+			}},
+		},
+		{
+			"hashtable",
+			`<Obj RefId="1"><TN RefId="1"><T>System.Collections.Hashtable</T></TN>` +
+				`<DCT><En><S N="Key">a</S><I32 N="Value">1</I32></En></DCT></Obj>`,
+			[]*CliXmlObject{{
+				Kind:      "Obj",
+				TypeNames: []string{"System.Collections.Hashtable"},
+				Entries: []CliXmlEntry{{
+					Key:   &CliXmlObject{Kind: "S", Name: "Key", Value: "a"},
+					Value: &CliXmlObject{Kind: "I32", Name: "Value", Value: "1"},
+				}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := parseCliXml(cliXmlArray(tt.items))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(root.Items, tt.want) {
+				t.Errorf("got items %+v, want %+v", root.Items, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCliXmlInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"not xml", "not xml"},
+		{"no root object", `<Objs Version="1.1.0.1"></Objs>`},
+		{"two root objects", `<Objs Version="1.1.0.1"><S>a</S><S>b</S></Objs>`},
+		{"unknown reference", cliXmlArray(`<Ref RefId="9" />`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseCliXml(tt.doc); err == nil {
+				t.Errorf("parseCliXml(%q) returned no error", tt.doc)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package gopwsh
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// SetCredential creates a PSCredential, from a SecureString, & stores it in
+// the global variable called name, so that it can be given to cmdlets that
+// need one, eg: Invoke-Command -Credential $name.
+//
+// The password is never passed to a Logger, even with VerboseLogging, or a
+// Tee, nor included in a returned error & the command that created the
+// credential is removed from the session's history. It is sent base64 encoded, in a
+// variable that is removed straight away, so that an error can never quote it
+// back. Keep in mind it is still written, as text, to PowerShell's STDIN & so
+// may be recorded by a Transcript.
+//
+// e.g:
+//
+//	shell.SetCredential("cred", "admin", password)
+//	shell.Execute("Invoke-Command -ComputerName srv01 -Credential $cred { hostname }")
+func (s *Shell) SetCredential(name, username, password string) error {
+	// Assigned on it's own line, the position text of an error only ever
+	// quotes the line that failed.
+	secret := QuoteArg(base64.StdEncoding.EncodeToString([]byte(password)))
+	cmd := "$gopwshSecret = " + secret + s.lineEnding +
+		"try { Set-Variable -Scope Global -Name " + QuoteArg(name) + " -Value (" +
+		"New-Object System.Management.Automation.PSCredential(" + QuoteArg(username) + ", " +
+		"(ConvertTo-SecureString -String ([Text.Encoding]::UTF8.GetString([Convert]::FromBase64String($gopwshSecret))) -AsPlainText -Force))) " +
+		"} finally { Remove-Variable gopwshSecret }"
+
+	discard := func(string) {}
+	ctx := withSecret(context.Background(), secret)
+	if err := s.run(ctx, cmd, discard, discard); err != nil {
+		return goerr.Wrap(redactError(ctx, err), "failed to set credential", name)
+	}
+
+	// Only once the command has completed is it added to the history
+	_, _, err := s.execute(context.Background(),
+		"$gopwshHistory = Get-History -Count 1; if ($gopwshHistory) { Clear-History -Id $gopwshHistory.Id }",
+	)
+	if err != nil {
+		return goerr.Wrap(err, "failed to remove credential from history", name)
+	}
+	return nil
+}
+
+// MustSetCredential is the same as SetCredential but panics on error instead of returning an error.
+func (s *Shell) MustSetCredential(name, username, password string) {
+	goerr.Check(s.SetCredential(name, username, password))
+}
@@ -0,0 +1,124 @@
+package gopwsh
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/brad-jones/goerr/v2"
+	"github.com/brad-jones/gopwsh/backend"
+)
+
+// credentialCmd returns the command SetCredential sends, as recorded by a Mock.
+func credentialCmd(t *testing.T, password string) string {
+	t.Helper()
+	b := backend.NewMock()
+	s := newMockShell(t, b)
+	if err := s.SetCredential("cred", "admin", password); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range b.Commands() {
+		if strings.Contains(cmd, "$gopwshSecret") {
+			return cmd
+		}
+	}
+	t.Fatalf("no credential command found in %q", b.Commands())
+	return ""
+}
+
+func TestSetCredentialError(t *testing.T) {
+	const password = "hunter2"
+	secret := base64.StdEncoding.EncodeToString([]byte(password))
+	cmd := credentialCmd(t, password)
+
+	b := backend.NewMock().Expect(cmd, "", "ParserError: $gopwshSecret = '"+secret+"'\n")
+	s := newMockShell(t, b)
+
+	err := s.SetCredential("cred", "admin", password)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if strings.Contains(err.Error(), secret) || strings.Contains(err.Error(), password) {
+		t.Errorf("error contains the password: %v", err)
+	}
+
+	var parserErr *ParserError
+	if !errors.As(err, &parserErr) {
+		t.Fatalf("errors.As did not find a *ParserError in %v", err)
+	}
+	if strings.Contains(parserErr.Output, secret) {
+		t.Errorf("ParserError.Output contains the password: %q", parserErr.Output)
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	ctx := withSecret(context.Background(), "'c2VjcmV0'")
+
+	tests := []struct {
+		name   string
+		err    error
+		target interface{}
+	}{
+		{"runtime error", goerr.Wrap(&RuntimeError{Terminating: true, Message: "bad 'c2VjcmV0'"}, "failed"), new(*RuntimeError)},
+		{"parser error", goerr.Wrap(&ParserError{Pattern: "ParserError", Output: "ParserError: 'c2VjcmV0'"}), new(*ParserError)},
+		{"closed", goerr.Wrap(&ShellClosedError{Reason: "it was closed"}, "sending 'c2VjcmV0'"), new(*ShellClosedError)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := redactError(ctx, tt.err)
+			if strings.Contains(err.Error(), "c2VjcmV0") {
+				t.Errorf("error contains the secret: %v", err)
+			}
+			if !errors.As(err, tt.target) {
+				t.Errorf("errors.As did not find %T in %v", tt.target, err)
+			}
+		})
+	}
+}
+
+func TestSetCredentialMetrics(t *testing.T) {
+	const password = "hunter2"
+	secret := base64.StdEncoding.EncodeToString([]byte(password))
+	cmd := credentialCmd(t, password)
+
+	tests := []struct {
+		name   string
+		stderr string
+	}{
+		{"success", ""},
+		{"failure", "ParserError: $gopwshSecret = '" + secret + "'\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var measured []CommandMetrics
+
+			b := backend.NewMock().Expect(cmd, "", tt.stderr)
+			s := newMockShell(t, b, Metrics(func(m CommandMetrics) {
+				mu.Lock()
+				defer mu.Unlock()
+				measured = append(measured, m)
+			}))
+			s.SetCredential("cred", "admin", password)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(measured) == 0 {
+				t.Fatal("no commands were measured")
+			}
+			for _, m := range measured {
+				if strings.Contains(m.Command, secret) {
+					t.Errorf("CommandMetrics.Command contains the password: %q", m.Command)
+				}
+				if m.Err != nil && strings.Contains(m.Err.Error(), secret) {
+					t.Errorf("CommandMetrics.Err contains the password: %v", m.Err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package gopwsh
+
+import (
+	"github.com/brad-jones/goerr/v2"
+)
+
+// elevatedCmd checks for the Administrator role on Windows, where
+// [Environment]::OSVersion works with every edition of PowerShell, & for the
+// root user everywhere else.
+const elevatedCmd = "if ([Environment]::OSVersion.Platform -eq 'Win32NT') { " +
+	"([Security.Principal.WindowsPrincipal][Security.Principal.WindowsIdentity]::GetCurrent())" +
+	".IsInRole([Security.Principal.WindowsBuiltInRole]::Administrator) " +
+	"} else { [int](id -u) -eq 0 }"
+
+// IsElevated reports whether the PowerShell process is actually running as an
+// Administrator on Windows or as root elsewhere.
+//
+// This is not the same as asking for elevation with the Elevated option, eg:
+// sudo may have been configured to run as another user or, on Windows, UAC may
+// have given the process a filtered token.
+func (s *Shell) IsElevated() (bool, error) {
+	v, err := s.GetBool(elevatedCmd)
+	if err != nil {
+		return false, goerr.Wrap(err, "failed to check for elevation")
+	}
+	return v, nil
+}
+
+// MustIsElevated is the same as IsElevated but panics on error instead of returning an error.
+func (s *Shell) MustIsElevated() bool {
+	v, err := s.IsElevated()
+	goerr.Check(err)
+	return v
+}
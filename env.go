@@ -0,0 +1,43 @@
+package gopwsh
+
+import (
+	"github.com/brad-jones/goerr/v2"
+)
+
+// SetEnv sets an environment variable of the PowerShell process, the same as
+// $env:KEY = 'value', so it is seen by every command executed after it.
+//
+// Unlike the Env option this can be used at any time. A process restarted by
+// AutoRestart only has the variables given to Env.
+func (s *Shell) SetEnv(key, value string) error {
+	// Set-Item, rather than $env:KEY, so that any key can be used safely
+	_, _, err := s.Execute("Set-Item -LiteralPath " + QuoteArg("env:"+key) + " -Value " + QuoteArg(value))
+	if err != nil {
+		return goerr.Wrap(err, "failed to set environment variable", key)
+	}
+	return nil
+}
+
+// MustSetEnv is the same as SetEnv but panics on error instead of returning an error.
+func (s *Shell) MustSetEnv(key, value string) {
+	goerr.Check(s.SetEnv(key, value))
+}
+
+// GetEnv returns the value of an environment variable of the PowerShell
+// process, the same as $env:KEY. Like os.Getenv, an empty string is returned
+// when the variable is not set.
+func (s *Shell) GetEnv(key string) (string, error) {
+	// Written without a line ending so the value is returned exactly as is
+	stdout, _, err := s.Execute("Write-Host -NoNewline ([Environment]::GetEnvironmentVariable(" + QuoteArg(key) + "))")
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to get environment variable", key)
+	}
+	return stdout, nil
+}
+
+// MustGetEnv is the same as GetEnv but panics on error instead of returning an error.
+func (s *Shell) MustGetEnv(key string) string {
+	v, err := s.GetEnv(key)
+	goerr.Check(err)
+	return v
+}
@@ -0,0 +1,107 @@
+package gopwsh
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// ParserError is returned, wrapped, when the output of a command matches one
+// of the FatalErrors patterns, by default only "ParserError". PowerShell did
+// not run any of the command, see FatalErrors & RecoverableErrors for what
+// happens to the Shell afterwards.
+//
+// Test for it with errors.As.
+type ParserError struct {
+	// Pattern is the FatalErrors pattern that was matched.
+	Pattern string
+
+	// Output is the output that matched, along with anything written shortly
+	// after it, usually the full error message.
+	Output string
+}
+
+func (e *ParserError) Error() string {
+	return e.Output
+}
+
+// RuntimeError is returned, wrapped, when a command threw a terminating error
+// or, with ErrorActionStop, when PowerShell reports that it failed.
+//
+// Test for it with errors.As.
+type RuntimeError struct {
+	// Terminating is true when the command threw a terminating error, otherwise
+	// $? was false at the end of the command.
+	Terminating bool
+
+	// ExitCode is the value of $LASTEXITCODE at the end of the command.
+	ExitCode int
+
+	// Stderr is everything the command wrote to STDERR. It is only set by
+	// Execute & the other methods that return STDERR as a string, not by
+	// ExecuteStream or Start.
+	Stderr string
+
+	// The details of a terminating error, these are only set with AutoTryCatch.
+	Message          string
+	Category         string
+	ErrorID          string
+	ExceptionType    string
+	ScriptStackTrace string
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Terminating {
+		if e.Message != "" {
+			return "The command threw a terminating error: " + e.Message
+		}
+		return "The command threw a terminating error"
+	}
+	return "The command failed"
+}
+
+// errorDetailCmd is run inside the catch block with AutoTryCatch, it has it's
+// own try/catch so that it can never stop the markers from being written.
+const errorDetailCmd = "try { $gopwshDetail = ' ' + [Convert]::ToBase64String([Text.Encoding]::UTF8.GetBytes((" +
+	"ConvertTo-Json -Compress -InputObject @{ " +
+	"Message = $_.Exception.Message; " +
+	"Category = [string]$_.CategoryInfo.Category; " +
+	"ErrorID = $_.FullyQualifiedErrorId; " +
+	"ExceptionType = $_.Exception.GetType().FullName; " +
+	"ScriptStackTrace = $_.ScriptStackTrace " +
+	"}))) } catch {}; "
+
+// parseErrorDetail fills in the details written by errorDetailCmd, they are
+// only informational so anything that can't be parsed is ignored.
+func parseErrorDetail(detail string, e *RuntimeError) {
+	decoded, err := base64.StdEncoding.DecodeString(detail)
+	if err != nil {
+		return
+	}
+	json.Unmarshal(decoded, e)
+}
+
+// ShellClosedError is returned, wrapped, when a command can't be executed
+// because the Shell has been closed, or is closed because the PowerShell
+// process died while the command was executing.
+//
+// Test for it with errors.As.
+type ShellClosedError struct {
+	// Reason describes why the Shell is closed.
+	Reason string
+
+	// Err is the underlying cause, if any, eg: ErrIdleClosed or the error
+	// from reading a pipe that was closed by the dead process.
+	Err error
+}
+
+func (e *ShellClosedError) Error() string {
+	if e.Err == nil {
+		return e.Reason
+	}
+	return fmt.Sprintf("%s: %s", e.Reason, e.Err)
+}
+
+func (e *ShellClosedError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,108 @@
+package gopwsh
+
+import (
+	"sync"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// BackendFunc is like Backend but calls fn to create a new backend for every
+// Shell that the decorator is applied to. Use this instead of Backend with a
+// ShellFactory or Pool, so that each of their shells gets it's own backend.
+// fn should not connect to anything, it may be called more than once per Shell.
+//
+// e.g:
+//
+//	gopwsh.BackendFunc(func() gopwsh.Starter { return backend.NewDocker("my-container") })
+func BackendFunc(fn func() Starter) func(*Shell) error {
+	return func(s *Shell) error {
+		s.backend = fn()
+		return nil
+	}
+}
+
+// ShellFactory creates many shells that all share the same decorators.
+//
+// The decorators are applied again for every Shell, so the Backend decorator
+// would give them all the very same backend instance. The backends in this
+// module can only run a single process at a time, so New returns an error
+// rather than start a second Shell with a backend that is still in use by
+// another one. Use BackendFunc to create a new backend for each Shell. A
+// backend is forgotten once it's Shell has been exited or killed.
+//
+// Create new instances of this with the "Factory()" function.
+type ShellFactory struct {
+	backends   map[Starter]*Shell
+	decorators []func(*Shell) error
+	mu         sync.Mutex
+}
+
+// Factory captures a set of decorators, see New, for creating many shells.
+//
+// e.g:
+//
+//	f := gopwsh.Factory(gopwsh.NoProfile(), gopwsh.ErrorActionStop())
+//	shell1, err := f.New()
+//	shell2, err := f.New()
+func Factory(decorators ...func(*Shell) error) *ShellFactory {
+	return &ShellFactory{
+		backends:   map[Starter]*Shell{},
+		decorators: decorators,
+	}
+}
+
+// New creates a new Shell with the factory's decorators, followed by any
+// extra decorators given here.
+func (f *ShellFactory) New(decorators ...func(*Shell) error) (*Shell, error) {
+	all := append(append([]func(*Shell) error{}, f.decorators...), decorators...)
+
+	// Apply the decorators to a throw away Shell to find out which backend
+	// will be used, they only set fields so this has no other side effects.
+	probe := &Shell{}
+	for _, decorator := range all {
+		if err := decorator(probe); err != nil {
+			return nil, goerr.Wrap(err, "failed to apply decorators")
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if probe.backend != nil {
+		if other, ok := f.backends[probe.backend]; ok && !other.IsClosed() {
+			return nil, goerr.New("The backend is already in use by another Shell from this factory, use BackendFunc to create a new backend for each Shell")
+		}
+	}
+
+	s, err := New(append(all, f.forget)...)
+	if err != nil {
+		return nil, err
+	}
+	if probe.backend != nil && probe.backend == s.starter && !s.IsClosed() {
+		f.backends[s.starter] = s
+	}
+	return s, nil
+}
+
+// forget is a decorator that removes the Shell's backend from the factory once
+// the Shell has been closed.
+func (f *ShellFactory) forget(s *Shell) error {
+	s.onClose = func() {
+		// In the background as the lock is held by New while a Shell starts
+		go func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if f.backends[s.starter] == s {
+				delete(f.backends, s.starter)
+			}
+		}()
+	}
+	return nil
+}
+
+// MustNew is the same as New but panics on error instead of returning an error.
+func (f *ShellFactory) MustNew(decorators ...func(*Shell) error) *Shell {
+	s, err := f.New(decorators...)
+	goerr.Check(err)
+	return s
+}
@@ -0,0 +1,78 @@
+package gopwsh
+
+import (
+	"github.com/brad-jones/goerr/v2"
+)
+
+// ExecuteFile runs a PowerShell script file, ie: a ".ps1" file, with the given
+// arguments. The path & each argument are quoted with QuoteArg so they may
+// contain spaces or quotes.
+//
+// The script is invoked with the call operator, eg: & 'C:\my script.ps1' 'foo'
+// so it runs in the current session but in it's own scope, any variables it
+// defines will not exist for later commands.
+//
+// Keep in mind the path is resolved by the PowerShell process, relative paths
+// are relative to it's current location & when using a remote backend the
+// file must exist on the remote host.
+func (s *Shell) ExecuteFile(path string, args ...string) (string, string, error) {
+	cmd := "& " + QuoteArg(path)
+	for _, arg := range args {
+		cmd = cmd + " " + QuoteArg(arg)
+	}
+
+	stdout, stderr, err := s.Execute(cmd)
+	if err != nil {
+		return stdout, stderr, goerr.Wrap(err, "failed to execute file", path)
+	}
+	return stdout, stderr, nil
+}
+
+// MustExecuteFile is the same as ExecuteFile but panics on error instead of returning an error.
+func (s *Shell) MustExecuteFile(path string, args ...string) (string, string) {
+	stdout, stderr, err := s.ExecuteFile(path, args...)
+	goerr.Check(err)
+	return stdout, stderr
+}
+
+// TestPath reports whether a path exists, with Test-Path. The path is taken
+// literally, wildcards such as "*" are not expanded.
+//
+// Like ExecuteFile the path is resolved by the PowerShell process.
+func (s *Shell) TestPath(path string) (bool, error) {
+	exists, err := s.GetBool("Test-Path -LiteralPath " + QuoteArg(path))
+	if err != nil {
+		return false, goerr.Wrap(err, "failed to test path", path)
+	}
+	return exists, nil
+}
+
+// MustTestPath is the same as TestPath but panics on error instead of returning an error.
+func (s *Shell) MustTestPath(path string) bool {
+	exists, err := s.TestPath(path)
+	goerr.Check(err)
+	return exists
+}
+
+// PathType returns "File", "Directory" or "NotFound" for a path, the same as
+// TestPath does. Any container, eg: a registry key, is reported as a
+// "Directory" & any leaf as a "File".
+func (s *Shell) PathType(path string) (string, error) {
+	quoted := QuoteArg(path)
+	pathType, err := s.GetString(
+		"if (Test-Path -LiteralPath " + quoted + " -PathType Container) { 'Directory' } " +
+			"elseif (Test-Path -LiteralPath " + quoted + " -PathType Leaf) { 'File' } " +
+			"else { 'NotFound' }",
+	)
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to get the type of path", path)
+	}
+	return pathType, nil
+}
+
+// MustPathType is the same as PathType but panics on error instead of returning an error.
+func (s *Shell) MustPathType(path string) string {
+	pathType, err := s.PathType(path)
+	goerr.Check(err)
+	return pathType
+}
@@ -0,0 +1,120 @@
+package gopwsh
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// GetString evaluates a single expression, or command, & returns it's output
+// with any leading & trailing whitespace trimmed.
+//
+// e.g:
+//
+//	name, err := shell.GetString("$env:COMPUTERNAME")
+func (s *Shell) GetString(expr string) (string, error) {
+	stdout, _, err := s.Execute(expr)
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to evaluate", expr)
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// MustGetString is the same as GetString but panics on error instead of returning an error.
+func (s *Shell) MustGetString(expr string) string {
+	v, err := s.GetString(expr)
+	goerr.Check(err)
+	return v
+}
+
+// ErrStderr is returned, wrapped along with the output, by ExecuteLine when a
+// command wrote to STDERR, see LineAllowStderr.
+var ErrStderr = errors.New("command wrote to stderr")
+
+// LineAllowStderr stops ExecuteLine from returning an error when a command
+// writes to STDERR but otherwise succeeds, eg: a native command that writes
+// progress to STDERR. Defaults to false.
+func LineAllowStderr(v bool) func(*Shell) error {
+	return func(s *Shell) error {
+		s.allowStderr = v
+		return nil
+	}
+}
+
+// ExecuteLine is the same as GetString but also returns an error, ErrStderr,
+// when the command wrote anything to STDERR, unless LineAllowStderr is set.
+//
+// e.g:
+//
+//	version, err := shell.ExecuteLine("git --version")
+func (s *Shell) ExecuteLine(cmd string) (string, error) {
+	stdout, stderr, err := s.Execute(cmd)
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to execute", cmd)
+	}
+	if !s.allowStderr && strings.TrimSpace(stderr) != "" {
+		return "", goerr.Wrap(ErrStderr, "failed to execute", cmd, stderr)
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// MustExecuteLine is the same as ExecuteLine but panics on error instead of returning an error.
+func (s *Shell) MustExecuteLine(cmd string) string {
+	v, err := s.ExecuteLine(cmd)
+	goerr.Check(err)
+	return v
+}
+
+// GetInt is the same as GetString but parses the output as an int, an error
+// is returned if the output is not a single integer.
+//
+// e.g:
+//
+//	count, err := shell.GetInt("(Get-ChildItem).Count")
+func (s *Shell) GetInt(expr string) (int, error) {
+	v, err := s.GetString(expr)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, goerr.Wrap(err, "failed to parse the output as an int", expr)
+	}
+	return i, nil
+}
+
+// MustGetInt is the same as GetInt but panics on error instead of returning an error.
+func (s *Shell) MustGetInt(expr string) int {
+	v, err := s.GetInt(expr)
+	goerr.Check(err)
+	return v
+}
+
+// GetBool is the same as GetString but parses the output as a bool, ie:
+// "True" or "False" in any case, an error is returned for any other output.
+//
+// e.g:
+//
+//	exists, err := shell.GetBool("Test-Path C:\\temp")
+func (s *Shell) GetBool(expr string) (bool, error) {
+	v, err := s.GetString(expr)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case strings.EqualFold(v, "True"):
+		return true, nil
+	case strings.EqualFold(v, "False"):
+		return false, nil
+	}
+	return false, goerr.Wrap("failed to parse the output as a bool", expr, v)
+}
+
+// MustGetBool is the same as GetBool but panics on error instead of returning an error.
+func (s *Shell) MustGetBool(expr string) bool {
+	v, err := s.GetBool(expr)
+	goerr.Check(err)
+	return v
+}
@@ -1,10 +1,24 @@
 module github.com/brad-jones/gopwsh
 
-go 1.16
+go 1.17
 
 require (
 	github.com/brad-jones/goasync/v2 v2.1.2
 	github.com/brad-jones/goerr/v2 v2.1.3
 	github.com/brad-jones/goexec/v2 v2.1.7
+	github.com/masterzen/winrm v0.0.0-20200615185753-c42b5136ff88
 	github.com/thanhpk/randstr v1.0.4
+	golang.org/x/crypto v0.14.0
+	golang.org/x/text v0.13.0
+)
+
+require (
+	github.com/Azure/go-ntlmssp v0.0.0-20180810175552-4a21cbd618b4 // indirect
+	github.com/ChrisTrenkamp/goxpath v0.0.0-20170922090931-c385f95c6022 // indirect
+	github.com/brad-jones/goprefix/v2 v2.0.5 // indirect
+	github.com/gofrs/uuid v3.2.0+incompatible // indirect
+	github.com/logrusorgru/aurora/v3 v3.0.0 // indirect
+	github.com/masterzen/simplexml v0.0.0-20190410153822-31eea3082786 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 )
@@ -1,10 +1,20 @@
 package gopwsh
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/brad-jones/goasync/v2/await"
@@ -12,11 +22,15 @@ import (
 	"github.com/brad-jones/goerr/v2"
 	"github.com/brad-jones/gopwsh/backend"
 	"github.com/thanhpk/randstr"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
 )
 
 var newLine string
 
-const bufferSize int = 64
+const defaultBufferSize int = 32 * 1024
+
+const defaultExitTimeout = 10 * time.Second
 
 func init() {
 	newLine = "\n"
@@ -27,9 +41,19 @@ func init() {
 
 // Starter describes what we use to actually "start" a powershell process.
 //
-// This module includes an implementation for running processes locally.
-// Other implementations such as starting a process via SSH are possible
-// but "at this stage" are left as an exercise for the reader - PRs welcome :)
+// This module includes implementations for running processes locally, in a
+// docker container, in WSL & on a remote host via SSH or WinRM, as well as an
+// in-memory Mock for testing, see the backend package. Other implementations
+// are possible but "at this stage" are left as an exercise for the reader - PRs
+// welcome :)
+//
+// Stdin is treated as a byte stream, a command may be split across several
+// Writes, of up to 64KB each, & a line may be split between them. If the
+// writer buffers it must also implement Flush() error, which is called once
+// the whole command has been written, so that the command reaches the process.
+// A Starter whose Stdin is message based rather than a stream should implement
+// Framer. Stdout & Stderr are read continuously from the moment the process
+// starts, so no output can be missed no matter how quickly a command completes.
 type Starter interface {
 	LookPath(file string) (string, error)
 	SetEnv(values map[string]string, combined bool)
@@ -41,16 +65,157 @@ type Starter interface {
 	Wait() error
 }
 
+// Killer is an optional interface that a Starter can implement to allow the
+// PowerShell process to be forcefully terminated, eg: with SIGKILL or
+// TerminateProcess. All the backends in this module implement it.
+type Killer interface {
+	Kill() error
+}
+
+// Interrupter is an optional interface that a Starter can implement to allow
+// the command that is currently executing to be stopped, like pressing Ctrl+C,
+// without killing the PowerShell process. The Local & SSH backends implement it.
+type Interrupter interface {
+	Interrupt() error
+}
+
+// EnvExcluder is an optional interface that a Starter can implement to allow
+// variables to be removed from the parent's environment, see EnvExclude.
+// The Local backend implements it.
+type EnvExcluder interface {
+	ExcludeEnv(keys ...string)
+}
+
+// Impersonator is an optional interface that a Starter can implement to start
+// the PowerShell process as another user, see RunAs. The Local backend
+// implements it, but only supports it on Windows.
+type Impersonator interface {
+	RunAs(username, password string)
+}
+
+// TimeoutWaiter is an optional interface that a Starter can implement to wait
+// for the PowerShell process to exit for no longer than d, returning an error
+// that wraps backend.ErrWaitTimeout when it has not. Wait, or WaitTimeout,
+// must be able to be called again afterwards, eg: once the process has been
+// killed. It is used by Exit, see GracefulExitTimeout. The Local, Docker &
+// WSL backends implement it.
+type TimeoutWaiter interface {
+	WaitTimeout(d time.Duration) error
+}
+
+// Framer is an optional interface that a Starter can implement when it can't
+// treat Stdin as a byte stream, eg: each Write is sent as a separate message.
+// WriteFrame is given each command in full, including it's line ending, & must
+// deliver it as a single message or return an error. Stdin is never written to
+// directly when a Starter implements Framer. The WinRM backend implements it.
+type Framer interface {
+	WriteFrame(b []byte) error
+}
+
+// LineEnder is an optional interface that a Starter can implement to tell us
+// the line ending, "\n" or "\r\n", of the OS that PowerShell runs on, which
+// is used to terminate each command. Starters that don't implement it are
+// assumed to run on the same OS as us, eg: the Mock backend.
+type LineEnder interface {
+	LineEnding() string
+}
+
+// ErrPwshNotFound is returned, wrapped, by New when neither "pwsh" nor
+// "powershell" can be found by the backend, test for it with errors.Is.
+var ErrPwshNotFound = errors.New("PowerShell binary not found")
+
+// pwshNotFound wraps ErrPwshNotFound with what was searched & how to fix it.
+func pwshNotFound(b Starter, names []string) error {
+	where := "on the backend's PATH"
+	if _, ok := b.(*backend.Local); ok {
+		where = "in " + strings.Join(filepath.SplitList(os.Getenv("PATH")), ", ")
+	}
+	return goerr.Wrap(ErrPwshNotFound, fmt.Sprintf(
+		"Failed to locate a PowerShell binary, searched for %s %s. "+
+			"Install PowerShell or use PwshLocation() to set it's location",
+		strings.Join(names, " & "), where,
+	))
+}
+
 // Shell is the primary object that represents a running PowerShell process.
 //
+// A Shell is safe for concurrent use by multiple goroutines, however there is
+// only a single PowerShell process so commands are executed one at a time.
+// Each command is executed atomically but note that when passing multiple
+// commands to Execute, commands from other goroutines may run in between.
+//
 // Create new instances of this with the "New()" function.
 type Shell struct {
-	backend      Starter
-	env          map[string]string
-	envCombined  bool
-	pwshLocation string
-	sudoLocation string
-	wd           string
+	mu             sync.Mutex
+	alive          int32
+	allowStderr    bool
+	autoRestart    bool
+	autoTryCatch   bool
+	backend        Starter
+	boundaryFunc   func() string
+	boundaryRand   io.Reader
+	bufferSize     int
+	cancel         context.CancelFunc
+	cancelMu       sync.Mutex
+	closeStdin     bool
+	dryRun         bool
+	env            map[string]string
+	envCombined    bool
+	envExclude     []string
+	errorStop      bool
+	exitCode       int
+	exitCodeSet    bool
+	exitInfo       *exitInfo
+	exitTimeout    time.Duration
+	fatalErrors    []string
+	healthCheck    bool
+	history        []string
+	historyMu      sync.Mutex
+	initialDir     string
+	interrupter    Interrupter
+	idleClosed     bool
+	idleGen        int
+	idleTimeout    time.Duration
+	idleTimer      *time.Timer
+	jsonDepth      int
+	lineEnding     string
+	killer         Killer
+	lastUsed       time.Time
+	logger         func(LogEvent)
+	logMu          sync.Mutex
+	logVerbose     bool
+	maxHistory     int
+	metrics        func(m CommandMetrics)
+	onClose        func()
+	onExit         func(err error)
+	outputEncoding string
+	pwshArgs       []string
+	pid            int
+	pidMu          sync.Mutex
+	preferWinPS    bool
+	preferences    []string
+	promptTimeout  time.Duration
+	pwshLocation   string
+	recoverable    bool
+	runAsPassword  string
+	runAsUser      string
+	starter        Starter
+	startup        []string
+	stripANSI      bool
+	sudoArgs       []string
+	stderr         *stream
+	stderrEncoding encoding.Encoding
+	stderrRing     *ringBuffer
+	stdout         *stream
+	stdoutEncoding encoding.Encoding
+	sudoLocation   string
+	teeStderr      io.Writer
+	teeStdout      io.Writer
+	transcript     bool
+	version        *PSVersion
+	versionMu      sync.Mutex
+	wd             string
+	xmlDepth       int
 }
 
 // Backend allows you set a custom backend or "Starter".
@@ -84,6 +249,89 @@ func Elevated(sudoLocation ...string) func(*Shell) error {
 	}
 }
 
+// LineEnding overrides the line ending, "\n" or "\r\n", used to terminate
+// the commands written to PowerShell's STDIN, eg: for a Docker backend with
+// Windows containers.
+//
+// Defaults to the line ending of the OS that the backend runs PowerShell on,
+// see LineEnder, otherwise that of the OS this was compiled for.
+func LineEnding(v string) func(*Shell) error {
+	return func(s *Shell) error {
+		if v != "\n" && v != "\r\n" {
+			return goerr.New(fmt.Sprintf("LineEnding must be \"\\n\" or \"\\r\\n\", got %q", v))
+		}
+		s.lineEnding = v
+		return nil
+	}
+}
+
+// ModulePath prepends directories to $env:PSModulePath as soon as the
+// PowerShell process has started, so that modules in them, eg: bundled with
+// your program, can be imported by name. The separator of the OS PowerShell
+// runs on is used & the inherited PSModulePath is kept after them.
+//
+// Paths are resolved by the PowerShell process, so must be absolute or
+// relative to the WorkingDir.
+func ModulePath(paths ...string) func(*Shell) error {
+	return func(s *Shell) error {
+		if len(paths) == 0 {
+			return nil
+		}
+		quoted := make([]string, len(paths))
+		for i, p := range paths {
+			quoted[i] = QuoteArg(p)
+		}
+		s.startup = append(s.startup, "$env:PSModulePath = (@("+strings.Join(quoted, ", ")+
+			", $env:PSModulePath) | Where-Object { $_ }) -join [System.IO.Path]::PathSeparator")
+		return nil
+	}
+}
+
+// Prelude runs a script, eg: one that defines helper functions, as soon as
+// the PowerShell process has started so the Shell is ready to use once New
+// returns. It is run again if the process is restarted by AutoRestart.
+//
+// The script is dot sourced so anything it defines exists for every command,
+// Reset keeps it too. Any error, terminating or not, stops the script & makes
+// New return an error.
+func Prelude(script string) func(*Shell) error {
+	return func(s *Shell) error {
+		s.startup = append(s.startup, "$gopwshEAP = $ErrorActionPreference; $ErrorActionPreference = 'Stop'; "+
+			"try { . {\n"+script+"\n} } finally { $ErrorActionPreference = $gopwshEAP }")
+		return nil
+	}
+}
+
+// RunAs starts PowerShell as another user, with their username & password,
+// instead of as the current user. Unlike Elevated this changes who PowerShell
+// runs as, eg: a non-admin service account, rather than raising privilege.
+//
+// Only the Local backend supports this & only on Windows, see Local.RunAs for
+// what is required. Elsewhere use sudo, eg: Elevated() with SudoArgs("-u", username).
+//
+// The password is passed straight to Windows, it is never written to a command
+// line or to PowerShell, but it is kept in memory so that AutoRestart can start
+// the process again.
+func RunAs(username, password string) func(*Shell) error {
+	return func(s *Shell) error {
+		s.runAsUser = username
+		s.runAsPassword = password
+		return nil
+	}
+}
+
+// SudoArgs adds arguments to the sudo invocation used by Elevated, they are
+// inserted before the path to PowerShell. eg: to describe why elevation is
+// needed in gsudo's prompt: SudoArgs("-d", "Installing updates").
+//
+// Has no effect unless Elevated is also used.
+func SudoArgs(args ...string) func(*Shell) error {
+	return func(s *Shell) error {
+		s.sudoArgs = append(s.sudoArgs, args...)
+		return nil
+	}
+}
+
 // WorkingDir allows you to set a custom initial working directory for the
 // PowerShell process.
 func WorkingDir(wd string) func(*Shell) error {
@@ -110,6 +358,19 @@ func EnvCombined(v bool) func(*Shell) error {
 	}
 }
 
+// EnvExclude removes the given variables from the backend's environment when
+// it is combined with the variables given to Env, see EnvCombined. eg: to
+// stop a PSModulePath set in the parent process from being inherited.
+//
+// Only backends that implement EnvExcluder support this, such as the Local
+// backend, New returns an error for any other backend.
+func EnvExclude(keys ...string) func(*Shell) error {
+	return func(s *Shell) error {
+		s.envExclude = keys
+		return nil
+	}
+}
+
 // PwshLocation allows you supply a custom path to a PowerShell executeable.
 func PwshLocation(path string) func(*Shell) error {
 	return func(s *Shell) error {
@@ -118,239 +379,1670 @@ func PwshLocation(path string) func(*Shell) error {
 	}
 }
 
-// New is a constructor like function for the Shell struct.
-//
-// All configuration is done through the functional options pattern.
-// see: https://dave.cheney.net/2014/10/17/functional-options-for-friendly-apis
-//
-// e.g:
-//	gopwsh.New(gopwsh.PwshLocation("/some/path/pwsh.exe"), ...)
-//
-// Some Defaults
-//
-// If no backend is set we will use the Local one.
-//
-// If no pwshLocation is set we will use the backend's LookPath method to first
-// look for an executebale named "pwsh". On failure of that we will look for an
-// executable named "powershell".
+// PreferWindowsPowerShell changes the lookup order used when no PwshLocation
+// is set, an executable named "powershell" is looked for first & then "pwsh".
 //
-// envCombined is set to true
-func New(decorators ...func(*Shell) error) (s *Shell, err error) {
-	defer goerr.Handle(func(e error) { s = nil; err = e })
-
-	s = &Shell{
-		envCombined: true,
+// Useful for modules that only load in Windows PowerShell 5.1. This applies
+// to Elevated shells too, only the PowerShell executable is affected.
+func PreferWindowsPowerShell() func(*Shell) error {
+	return func(s *Shell) error {
+		s.preferWinPS = true
+		return nil
 	}
-	for _, decorator := range decorators {
-		goerr.Check(decorator(s))
+}
+
+// BufferSize sets the size of the buffer used for each read from PowerShell's
+// STDOUT & STDERR pipes. Defaults to 32KB.
+func BufferSize(n int) func(*Shell) error {
+	return func(s *Shell) error {
+		if n < 1 {
+			return goerr.New(fmt.Sprintf("BufferSize must be greater than 0, got %d", n))
+		}
+		s.bufferSize = n
+		return nil
 	}
+}
 
-	if s.backend == nil {
-		s.backend = &backend.Local{}
+// ErrorActionStop sets $ErrorActionPreference = 'Stop' as soon as the
+// PowerShell process has started, turning all errors into terminating errors.
+//
+// Execute will then also return an error whenever PowerShell reports that a
+// command failed, ie: $? is false, instead of only returning the error text
+// in STDERR.
+func ErrorActionStop() func(*Shell) error {
+	return func(s *Shell) error {
+		s.errorStop = true
+		s.preferences = append(s.preferences, "$ErrorActionPreference = 'Stop'")
+		return nil
 	}
+}
 
-	s.backend.SetEnv(s.env, s.envCombined)
-	s.backend.SetWorkingDir(s.wd)
+// AutoTryCatch records the details of a terminating error, as structured data,
+// in the RuntimeError that is returned, ie: it's Message, Category, ErrorID,
+// ExceptionType & ScriptStackTrace. Combine it with ErrorActionStop to get
+// the details of every error.
+//
+// Commands are always executed inside a try/catch, with the caught error
+// written to STDERR, this additionally converts the error to JSON & sends it
+// back along with the command's exit code. Defaults to false.
+func AutoTryCatch(v bool) func(*Shell) error {
+	return func(s *Shell) error {
+		s.autoTryCatch = v
+		return nil
+	}
+}
 
-	if s.pwshLocation == "" {
-		if path, err := s.backend.LookPath("pwsh"); err == nil {
-			s.pwshLocation = path
-		} else {
-			path, err := s.backend.LookPath("powershell")
-			if err != nil {
-				goerr.Check(goerr.New("Failed to locate a PowerShell binary"))
-			}
-			s.pwshLocation = path
+// ErrorActionPreference sets $ErrorActionPreference as soon as the PowerShell
+// process has started, before any command is executed.
+//
+// The value is matched case insensitively against the known preferences, eg:
+// "Continue" or "SilentlyContinue", an error is returned for anything else.
+// "Stop" is the same as ErrorActionStop.
+func ErrorActionPreference(v string) func(*Shell) error {
+	return func(s *Shell) error {
+		pref, err := actionPreference("ErrorActionPreference", v)
+		if err != nil {
+			return err
+		}
+		if pref == "Stop" {
+			s.errorStop = true
 		}
+		s.preferences = append(s.preferences, "$ErrorActionPreference = '"+pref+"'")
+		return nil
 	}
+}
 
-	if s.sudoLocation != "" {
-		if s.sudoLocation == "sudo" {
-			path, err := s.backend.LookPath("sudo")
-			if err != nil {
-				goerr.Check(goerr.New("Failed to locate a sudo binary"))
-			}
-			s.sudoLocation = path
+// ProgressPreference sets $ProgressPreference as soon as the PowerShell
+// process has started, before any command is executed.
+//
+// ProgressPreference("SilentlyContinue") hides progress bars, which otherwise
+// end up in STDERR & slow down cmdlets such as Invoke-WebRequest massively.
+// Keep in mind ExecuteWithProgress won't see any progress records then.
+func ProgressPreference(v string) func(*Shell) error {
+	return func(s *Shell) error {
+		pref, err := actionPreference("ProgressPreference", v)
+		if err != nil {
+			return err
 		}
-		goerr.Check(
-			s.backend.StartProcess(s.sudoLocation,
-				s.pwshLocation, "-NoExit", "-Command", "-",
-			),
-			"Failed to start powershell process with sudo",
-			s.sudoLocation,
-			s.pwshLocation,
-		)
-		return
+		s.preferences = append(s.preferences, "$ProgressPreference = '"+pref+"'")
+		return nil
 	}
-
-	goerr.Check(
-		s.backend.StartProcess(s.pwshLocation, "-NoExit", "-Command", "-"),
-		"Failed to start powershell process",
-		s.pwshLocation,
-	)
-	return
 }
 
-// MustNew is the same as New but panics on error instead of returning an error.
-func MustNew(decorators ...func(*Shell) error) *Shell {
-	s, err := New(decorators...)
-	goerr.Check(err)
-	return s
+// Transcript records the whole session, every command & it's output, to a
+// file with Start-Transcript as soon as the PowerShell process has started.
+// The transcript is appended to if the file already exists & New returns an
+// error if it can't be started, eg: the directory doesn't exist.
+//
+// Exit stops the transcript, a process that is killed, or crashes, may leave
+// it incomplete. A process restarted by AutoRestart appends to the same file.
+func Transcript(path string) func(*Shell) error {
+	return func(s *Shell) error {
+		s.transcript = true
+		s.startup = append(s.startup, "$null = Start-Transcript -LiteralPath "+QuoteArg(path)+" -Append -ErrorAction Stop")
+		return nil
+	}
 }
 
-// Execute is what you can use to actually execute arbitrary powershell
-// commands & script.
-//
-// It will return the STDOUT & STDERR in 2 separate strings.
-// Even if STDERR is not empty then the err value will still be nil.
-// Only errors associated with this gopwsh module will be returned.
-//
-// Just because a command returns STDERR doesn't necessarily mean failure.
-// ie: some commands log progress messages / extra debugging info to STDERR
-// but still successfully perform their task.
-//
-// ParserErrors are however considered fatal and will result in an error value
-// being returned. The underlying PowerShell process will be killed and you
-// won't be able to use this instance of the Shell any longer.
-func (s *Shell) Execute(cmds ...string) (string, string, error) {
-	stdout := ""
-	stderr := ""
+// actionPreferences are the values of the ActionPreference enum.
+var actionPreferences = []string{
+	"Break", "Continue", "Ignore", "Inquire", "SilentlyContinue", "Stop", "Suspend",
+}
 
-	for _, cmd := range cmds {
-		o, e, err := s.execute(cmd)
-		stdout = stdout + o
-		stderr = stderr + e
-		if err != nil {
-			return stdout, stderr, goerr.Wrap(err, "failed to execute", cmd)
+func actionPreference(name, v string) (string, error) {
+	for _, p := range actionPreferences {
+		if strings.EqualFold(p, v) {
+			return p, nil
 		}
 	}
-
-	return stdout, stderr, nil
+	return "", goerr.New(fmt.Sprintf("Unknown %s %q, expected one of %s",
+		name, v, strings.Join(actionPreferences, ", "),
+	))
 }
 
-// MustExecute is the same as Execute but panics on error instead of returning an error.
-func (s *Shell) MustExecute(cmds ...string) (string, string) {
-	stdout, stderr, err := s.Execute(cmds...)
-	goerr.Check(err)
-	return stdout, stderr
+// FatalErrors sets the patterns that, when seen in the output of a command,
+// are considered fatal. ie: the underlying PowerShell process will be killed
+// and you won't be able to use this instance of the Shell any longer.
+//
+// Defaults to just "ParserError". A ParserError means PowerShell did not run
+// any part of the command, including the markers that tell us when to stop
+// reading the output, so you will almost certainly want to keep it.
+func FatalErrors(patterns ...string) func(*Shell) error {
+	return func(s *Shell) error {
+		s.fatalErrors = patterns
+		return nil
+	}
 }
 
-func (s *Shell) execute(cmd string) (string, string, error) {
-	if s.backend == nil {
-		return "", "", goerr.Wrap("Cannot execute commands on closed shells.", cmd)
+// OutputEncoding sets the encoding PowerShell uses when writing to it's STDOUT
+// & STDERR streams, as well as when piping to native commands ($OutputEncoding).
+//
+// The name is anything accepted by [System.Text.Encoding]::GetEncoding(),
+// eg: "utf-8" or "windows-1252". An empty string leaves PowerShell's own
+// default in place, which on Windows is the legacy console code page.
+//
+// Defaults to UTF-8 so that non-ASCII output is read consistently on every
+// platform, no BOM is written.
+func OutputEncoding(name string) func(*Shell) error {
+	return func(s *Shell) error {
+		s.outputEncoding = name
+		return nil
 	}
+}
 
-	// Wrap the command in special markers so we know when to stop reading from the pipes
-	outBoundary := createBoundary()
-	errBoundary := createBoundary()
-	full := fmt.Sprintf("%s; echo '%s'; [Console]::Error.WriteLine('%s')%s",
-		cmd, outBoundary, errBoundary, newLine,
-	)
-
-	// Send the command to the running powershell process via STDIN
-	_, err := s.backend.Stdin().Write([]byte(full))
-	if err != nil {
-		return "", "", goerr.Wrap(err, "Could not send PowerShell command", cmd)
+// StdoutEncoding sets the encoding used to decode PowerShell's STDOUT, eg:
+// charmap.Windows1252 from golang.org/x/text/encoding/charmap, for when the
+// output is not UTF-8 despite OutputEncoding, eg: a native command that
+// ignores it. Output is always UTF-8 once decoded.
+//
+// Defaults to nil, the output is assumed to be UTF-8 & is not decoded.
+func StdoutEncoding(enc encoding.Encoding) func(*Shell) error {
+	return func(s *Shell) error {
+		s.stdoutEncoding = enc
+		return nil
 	}
+}
 
-	// Read stdout and stderr
-	results, err := await.FastAllOrError(
-		streamReader(s.backend.Stdout(), outBoundary),
-		streamReader(s.backend.Stderr(), errBoundary),
-	)
-	if err != nil {
-		if strings.Contains(err.Error(), "ParserError") {
-			s.Exit()
-		}
-		return "", "", goerr.Wrap(err, "Failed to read stdout/stderr steams")
+// StderrEncoding is the same as StdoutEncoding but for STDERR, which on
+// Windows may well be in a different code page to STDOUT.
+func StderrEncoding(enc encoding.Encoding) func(*Shell) error {
+	return func(s *Shell) error {
+		s.stderrEncoding = enc
+		return nil
 	}
-	sout := results[0].(string)
-	serr := results[1].(string)
+}
 
-	return sout, serr, nil
+// RecoverableErrors changes what happens when a fatal error (see FatalErrors),
+// such as a ParserError, is seen in the output of a command.
+//
+// By default the underlying PowerShell process is killed & the Shell can no
+// longer be used. When set to true an error is still returned but instead the
+// output of the failed command is discarded & the Shell re-synchronises with
+// the PowerShell process so that it can continue to be used. If this fails the
+// process is killed.
+//
+// This does not change how any other errors are handled. Failed commands &
+// terminating errors never kill the process, whereas the process dying always
+// will & a cancelled command or a timeout will unless it can be interrupted,
+// see Interrupt.
+func RecoverableErrors(v bool) func(*Shell) error {
+	return func(s *Shell) error {
+		s.recoverable = v
+		return nil
+	}
 }
 
-// Exit is used to kill the powershell process.
+// GracefulExitTimeout sets how long Exit will wait for the PowerShell process
+// to exit after asking it nicely. Once the timeout expires the process is
+// killed, if the backend supports it, otherwise Exit returns without waiting
+// for the process any longer.
 //
-// Typical usage might look like:
-// 	shell := gopwsh.New()
-// 	defer shell.Exit()
-func (s *Shell) Exit() {
-	if s.backend == nil {
-		return
+// Defaults to 10 seconds, 0 waits forever. See also Shell.ExitTimeout.
+func GracefulExitTimeout(d time.Duration) func(*Shell) error {
+	return func(s *Shell) error {
+		s.exitTimeout = d
+		return nil
 	}
+}
 
-	s.backend.Stdin().Write([]byte("exit" + newLine))
+// CloseStdinOnExit controls whether Exit closes PowerShell's STDIN, when the
+// backend allows it, after sending the "exit" command.
+//
+// Closing STDIN ensures the process exits even if it ignored the "exit"
+// command but some remote backends will hang instead. Defaults to true.
+func CloseStdinOnExit(v bool) func(*Shell) error {
+	return func(s *Shell) error {
+		s.closeStdin = v
+		return nil
+	}
+}
 
-	// If it's possible to close stdin, do so.
-	// Some backends, like the local one, do support it.
-	closer, ok := s.backend.Stdin().(io.Closer)
-	if ok {
-		closer.Close()
+// AutoRestart makes the Shell transparently start a new PowerShell process when
+// it finds that the current one has died, the command that found this is then
+// retried once. The new process is started with the same options as the first,
+// eg: environment, working directory, encoding, etc.
+//
+// Any state held by the old process, such as variables, is lost & a command
+// that was streaming its output may see some lines twice. Cancelled commands,
+// timeouts, fatal errors (see FatalErrors) & closed shells are never restarted.
+//
+// Combine this with HealthCheck to also restart processes that have stopped
+// responding. The backend must support StartProcess being called again once
+// Wait has returned, all the backends in this module do, eg: the SSH backend
+// reconnects, see also it's KeepAlive.
+func AutoRestart(v bool) func(*Shell) error {
+	return func(s *Shell) error {
+		s.autoRestart = v
+		return nil
 	}
+}
 
-	s.backend.Wait()
-	s.backend = nil
+// HealthCheck makes every command first check that the PowerShell process is
+// still responding, see Ping. This costs an extra round trip per command but
+// means a dead process results in a quick error instead of a command that
+// hangs forever.
+func HealthCheck(v bool) func(*Shell) error {
+	return func(s *Shell) error {
+		s.healthCheck = v
+		return nil
+	}
 }
 
-// QuoteArg can be used to escape string literals that you want to ensure
-// don't get mangled between your Go code and PowerShell.
-func QuoteArg(s string) string {
-	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+// NoProfile starts PowerShell with the -NoProfile flag so that none of the
+// user's profile scripts are loaded. Useful for reproducible automation where
+// functions & aliases defined by a profile might change how commands behave.
+func NoProfile() func(*Shell) error {
+	return func(s *Shell) error {
+		s.pwshArgs = append(s.pwshArgs, "-NoProfile")
+		return nil
+	}
 }
 
-func streamReader(stream io.Reader, boundary string) *task.Task {
-	return task.New(func(t *task.Internal) {
-		output := ""
-		marker := boundary + newLine
+// NonInteractive starts PowerShell with the -NonInteractive flag, guaranteeing
+// that PowerShell will never prompt for input, eg: for credentials. Commands
+// that try to prompt will instead fail.
+func NonInteractive() func(*Shell) error {
+	return func(s *Shell) error {
+		s.pwshArgs = append(s.pwshArgs, "-NonInteractive")
+		return nil
+	}
+}
 
-		_, err := await.FastAny(
-			task.New(func(t *task.Internal) {
-				for {
-					if t.ShouldStop() {
-						return
-					}
+// executionPolicies are the values accepted by -ExecutionPolicy.
+var executionPolicies = []string{
+	"AllSigned", "Bypass", "Default", "RemoteSigned", "Restricted", "Undefined", "Unrestricted",
+}
 
-					if strings.Contains(output, "ParserError") {
-						time.Sleep(time.Millisecond * 1)
-						break
-					}
+// BoundaryFunc replaces the function used to create the boundaries that mark
+// the end of each command's output, eg: for environments that mangle the "$"
+// of the default boundaries or for deterministic boundaries in tests.
+//
+// Each boundary must be unique enough that it never appears in the output of
+// a command. An error is returned by any command for which fn returns an empty
+// boundary or one that contains a line ending.
+//
+// Defaults to "$gopwsh" followed by 24 random hex characters & another "$".
+func BoundaryFunc(fn func() string) func(*Shell) error {
+	return func(s *Shell) error {
+		s.boundaryFunc = fn
+		return nil
+	}
+}
 
-					time.Sleep(time.Millisecond * 1)
-				}
+// BoundaryRand replaces the source of randomness used to create the default
+// boundaries, eg: a seeded math/rand.Rand for deterministic boundaries in
+// tests or an entropy source of your choosing. It is ignored when BoundaryFunc
+// is set.
+//
+// Reads from r are serialised, even between Shells, so r does not need to be
+// safe for concurrent use. Defaults to crypto/rand.
+func BoundaryRand(r io.Reader) func(*Shell) error {
+	return func(s *Shell) error {
+		s.boundaryRand = r
+		return nil
+	}
+}
 
-				t.Reject(output)
-			}),
-			task.New(func(t *task.Internal) {
-				for {
-					if t.ShouldStop() {
-						return
-					}
+// DryRun makes every command return the text that would have been written to
+// PowerShell's STDIN as it's STDOUT, see ComposeCommand, instead of actually
+// executing it. The PowerShell process is still started & initialised.
+//
+// Keep in mind methods that parse the output, eg: ExecuteJSON, will fail.
+func DryRun(v bool) func(*Shell) error {
+	return func(s *Shell) error {
+		s.dryRun = v
+		return nil
+	}
+}
 
-					buf := make([]byte, bufferSize)
-					read, err := stream.Read(buf)
-					if err != nil {
-						t.Reject(err, "failed to read stream")
-						return
-					}
+// ExecutionPolicy starts PowerShell with the -ExecutionPolicy flag, eg:
+// ExecutionPolicy("Bypass") allows scripts to be run on machines that have
+// been locked down. It only applies to the PowerShell process, the policy of
+// the machine is not changed.
+//
+// The policy is matched case insensitively against the known policies, an
+// error is returned for anything else. Execution policies are only enforced
+// on Windows, other platforms accept & ignore the flag.
+func ExecutionPolicy(policy string) func(*Shell) error {
+	return func(s *Shell) error {
+		for _, p := range executionPolicies {
+			if strings.EqualFold(p, policy) {
+				s.pwshArgs = append(s.pwshArgs, "-ExecutionPolicy", p)
+				return nil
+			}
+		}
+		return goerr.New(fmt.Sprintf("Unknown execution policy %q, expected one of %s",
+			policy, strings.Join(executionPolicies, ", "),
+		))
+	}
+}
+
+// ExtraArgs allows you to append arbitrary flags to the arguments used to
+// start PowerShell, eg: ExtraArgs("-MTA").
+//
+// Do not pass -Command, -File or -NoExit as these are always added for you.
+func ExtraArgs(args ...string) func(*Shell) error {
+	return func(s *Shell) error {
+		s.pwshArgs = append(s.pwshArgs, args...)
+		return nil
+	}
+}
+
+// New is a constructor like function for the Shell struct.
+//
+// All configuration is done through the functional options pattern.
+// see: https://dave.cheney.net/2014/10/17/functional-options-for-friendly-apis
+//
+// e.g:
+//	gopwsh.New(gopwsh.PwshLocation("/some/path/pwsh.exe"), ...)
+//
+// Some Defaults
+//
+// If no backend is set we will use the Local one.
+//
+// If no pwshLocation is set we will use the backend's LookPath method to first
+// look for an executebale named "pwsh". On failure of that we will look for an
+// executable named "powershell". See PreferWindowsPowerShell to reverse this.
+//
+// bufferSize is set to 32KB
+//
+// exitTimeout is set to 10 seconds
+//
+// envCombined is set to true
+//
+// closeStdin is set to true
+//
+// fatalErrors is set to "ParserError"
+//
+// jsonDepth is set to 10
+//
+// maxHistory is set to 100
+//
+// outputEncoding is set to "utf-8"
+//
+// xmlDepth is set to 1
+func New(decorators ...func(*Shell) error) (*Shell, error) {
+	return NewContext(context.Background(), decorators...)
+}
+
+// NewContext is the same as New but gives up, killing the PowerShell process,
+// if it does not become ready before ctx is done, eg: a remote backend that
+// started but never responds.
+//
+// Once started PowerShell is sent a probe, much like Ping, & the startup
+// commands are only executed once it has responded. ctx is only used while
+// starting, it does not affect the Shell after NewContext has returned.
+//
+// e.g:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+//	defer cancel()
+//	shell, err := gopwsh.NewContext(ctx, gopwsh.Backend(b))
+func NewContext(ctx context.Context, decorators ...func(*Shell) error) (s *Shell, err error) {
+	defer goerr.Handle(func(e error) { s = nil; err = e })
+
+	s, err = configure(decorators...)
+	goerr.Check(err)
+
+	if k, ok := s.backend.(Killer); ok {
+		s.killer = k
+	}
+	if i, ok := s.backend.(Interrupter); ok {
+		s.interrupter = i
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.starter = s.backend
+	goerr.Check(s.start(ctx))
+	s.touch()
+
+	return
+}
+
+// configure creates a Shell with the defaults, applies the decorators & then
+// locates the executables, it is used by New & OneShot.
+func configure(decorators ...func(*Shell) error) (s *Shell, err error) {
+	defer goerr.Handle(func(e error) { s = nil; err = e })
+
+	s = &Shell{
+		bufferSize:     defaultBufferSize,
+		closeStdin:     true,
+		envCombined:    true,
+		exitTimeout:    defaultExitTimeout,
+		fatalErrors:    []string{"ParserError"},
+		jsonDepth:      10,
+		maxHistory:     100,
+		outputEncoding: "utf-8",
+		xmlDepth:       1,
+	}
+	for _, decorator := range decorators {
+		goerr.Check(decorator(s))
+	}
+
+	if s.backend == nil {
+		s.backend = &backend.Local{}
+	}
+
+	if s.lineEnding == "" {
+		s.lineEnding = newLine
+		if l, ok := s.backend.(LineEnder); ok {
+			s.lineEnding = l.LineEnding()
+		}
+	}
+
+	if s.pwshLocation == "" {
+		names := []string{"pwsh", "powershell"}
+		if s.preferWinPS {
+			names = []string{"powershell", "pwsh"}
+		}
+		for _, name := range names {
+			path, err := s.backend.LookPath(name)
+			if err == nil {
+				s.pwshLocation = path
+				break
+			}
+			if !errors.Is(err, backend.ErrNotFound) {
+				goerr.Check(err, "Failed to locate a PowerShell binary")
+			}
+		}
+		if s.pwshLocation == "" {
+			goerr.Check(pwshNotFound(s.backend, names))
+		}
+	}
+
+	if s.sudoLocation == "sudo" {
+		path, err := s.backend.LookPath("sudo")
+		if err != nil {
+			goerr.Check(goerr.New("Failed to locate a sudo binary"))
+		}
+		s.sudoLocation = path
+	}
+
+	return
+}
+
+// start spawns the PowerShell process & initialises the session, it is used by
+// New & again when AutoRestart is enabled. Must be called with the lock held.
+func (s *Shell) start(ctx context.Context) (err error) {
+	defer goerr.Handle(func(e error) { err = e })
+
+	// -Command must come last as everything after it is treated as the command
+	goerr.Check(s.startProcess("-NoExit", "-Command", "-"))
+
+	stdout := s.backend.Stdout()
+	if s.stdoutEncoding != nil {
+		stdout = transform.NewReader(stdout, s.stdoutEncoding.NewDecoder())
+	}
+	s.stdout = newStream(stdout, s.bufferSize)
+
+	stderr := s.backend.Stderr()
+	if s.stderrEncoding != nil {
+		stderr = transform.NewReader(stderr, s.stderrEncoding.NewDecoder())
+	}
+	if s.stderrRing != nil {
+		stderr = io.TeeReader(stderr, s.stderrRing)
+	}
+	s.stderr = newStream(stderr, s.bufferSize)
+
+	s.exitInfo = &exitInfo{done: make(chan struct{})}
+
+	if b, ok := s.backend.(interface{ PID() int }); ok {
+		s.pidMu.Lock()
+		s.pid = b.PID()
+		s.pidMu.Unlock()
+	}
+
+	// Wait for PowerShell to respond before sending anything else so that a
+	// backend that mis-starts fails fast, rather than hanging the first command
+	if err := s.resync(ctx); err != nil {
+		s.kill()
+		goerr.Check(err, "PowerShell did not become ready")
+	}
+
+	discard := func(string) {}
+	for _, cmd := range s.startupCmds() {
+		if _, err := s.runLocked(ctx, cmd, discard, discard); err != nil {
+			s.exit(s.exitTimeout)
+			goerr.Check(err, "Failed to initialise the PowerShell session")
+		}
+	}
+
+	if err := s.snapshot(); err != nil {
+		s.exit(s.exitTimeout)
+		goerr.Check(err, "Failed to initialise the PowerShell session")
+	}
+
+	if s.onExit != nil {
+		go s.monitor(s.exitInfo, s.stdout)
+	}
+
+	atomic.StoreInt32(&s.alive, 1)
+	return
+}
+
+// startProcess starts PowerShell with the backend, with sudo if Elevated, the
+// args are appended to any set by options such as NoProfile.
+func (s *Shell) startProcess(args ...string) (err error) {
+	defer goerr.Handle(func(e error) { err = e })
+
+	if len(s.envExclude) > 0 {
+		e, ok := s.backend.(EnvExcluder)
+		if !ok {
+			goerr.Check(goerr.New("EnvExclude is not supported by this backend"))
+		}
+		e.ExcludeEnv(s.envExclude...)
+	}
+	if s.runAsUser != "" {
+		i, ok := s.backend.(Impersonator)
+		if !ok {
+			goerr.Check(goerr.New("RunAs is not supported by this backend"))
+		}
+		i.RunAs(s.runAsUser, s.runAsPassword)
+	}
+	s.backend.SetEnv(s.env, s.envCombined)
+	s.backend.SetWorkingDir(s.wd)
+
+	args = append(append([]string{}, s.pwshArgs...), args...)
+
+	if s.sudoLocation != "" {
+		goerr.Check(
+			s.backend.StartProcess(s.sudoLocation,
+				append(append(append([]string{}, s.sudoArgs...), s.pwshLocation), args...)...,
+			),
+			"Failed to start powershell process with sudo",
+			s.sudoLocation,
+			s.pwshLocation,
+		)
+	} else {
+		goerr.Check(
+			s.backend.StartProcess(s.pwshLocation, args...),
+			"Failed to start powershell process",
+			s.pwshLocation,
+		)
+	}
+	return
+}
+
+// startupCmds are the commands that initialise a new PowerShell session, the
+// preferences followed by the commands that must only ever run once.
+func (s *Shell) startupCmds() []string {
+	return append(s.preferenceCmds(), s.startup...)
+}
+
+// preferenceCmds set the session preferences, eg: $ErrorActionPreference, they
+// can be run again at any time, see Reset.
+func (s *Shell) preferenceCmds() []string {
+	preferences := append([]string{}, s.preferences...)
+	if s.outputEncoding != "" {
+		preferences = append([]string{outputEncodingCmd(s.outputEncoding)}, preferences...)
+	}
+	return preferences
+}
+
+// restart replaces a PowerShell process that has died, or stopped responding,
+// with a brand new one. Must be called with the lock held.
+func (s *Shell) restart() error {
+	s.terminate()
+	s.backend = s.starter
+	if err := s.start(context.Background()); err != nil {
+		s.backend = nil
+		s.closed()
+		return err
+	}
+	return nil
+}
+
+// outputEncodingCmd builds the startup command for the OutputEncoding option.
+// UTF-8 is special cased as GetEncoding() would return an encoding that
+// writes a BOM at the start of the stream.
+func outputEncodingCmd(name string) string {
+	enc := fmt.Sprintf("[System.Text.Encoding]::GetEncoding(%s)", QuoteArg(name))
+	switch strings.ToLower(strings.ReplaceAll(name, "-", "")) {
+	case "utf8":
+		enc = "(New-Object System.Text.UTF8Encoding $false)"
+	}
+	return fmt.Sprintf("[Console]::OutputEncoding = %[1]s; $OutputEncoding = %[1]s", enc)
+}
+
+// MustNew is the same as New but panics on error instead of returning an error.
+func MustNew(decorators ...func(*Shell) error) *Shell {
+	s, err := New(decorators...)
+	goerr.Check(err)
+	return s
+}
+
+// Execute is what you can use to actually execute arbitrary powershell
+// commands & script.
+//
+// It will return the STDOUT & STDERR in 2 separate strings.
+// Even if STDERR is not empty then the err value will still be nil.
+// Only errors associated with this gopwsh module will be returned.
+//
+// Just because a command returns STDERR doesn't necessarily mean failure.
+// ie: some commands log progress messages / extra debugging info to STDERR
+// but still successfully perform their task.
+//
+// To tell the difference between a native command that logged to STDERR and
+// one that actually failed use LastExitCode after calling Execute.
+//
+// Terminating errors, such as those from "throw", always result in an error
+// value being returned. See also the ErrorActionStop option.
+//
+// ParserErrors are however considered fatal and will result in an error value
+// being returned. The underlying PowerShell process will be killed and you
+// won't be able to use this instance of the Shell any longer.
+// See also the FatalErrors option.
+//
+// using statements, eg: "using namespace System.Text", are only supported
+// on their own lines at the start of a command.
+func (s *Shell) Execute(cmds ...string) (string, string, error) {
+	return s.ExecuteContext(context.Background(), cmds...)
+}
+
+// MustExecute is the same as Execute but panics on error instead of returning an error.
+func (s *Shell) MustExecute(cmds ...string) (string, string) {
+	stdout, stderr, err := s.Execute(cmds...)
+	goerr.Check(err)
+	return stdout, stderr
+}
+
+// ExecuteContext is the same as Execute but will stop waiting for the
+// commands to complete once the given context is done.
+//
+// The command is stopped with the backend's Interrupter, see Interrupt, & the
+// Shell re-synchronises with the PowerShell process so that it can continue
+// to be used. Otherwise there is no way to know what state a PowerShell
+// process is left in when a command is abandoned part way through, some of
+// it's output may still be sitting in the pipes waiting to be read. So the
+// underlying PowerShell process will be killed and you won't be able to use
+// this instance of the Shell any longer. The returned error will wrap
+// ctx.Err().
+func (s *Shell) ExecuteContext(ctx context.Context, cmds ...string) (string, string, error) {
+	stdout := ""
+	stderr := ""
+
+	for _, cmd := range cmds {
+		o, e, err := s.execute(ctx, cmd)
+		stdout = stdout + o
+		stderr = stderr + e
+		if err != nil {
+			return stdout, stderr, goerr.Wrap(err, "failed to execute", cmd)
+		}
+	}
+
+	return stdout, stderr, nil
+}
+
+// MustExecuteContext is the same as ExecuteContext but panics on error instead of returning an error.
+func (s *Shell) MustExecuteContext(ctx context.Context, cmds ...string) (string, string) {
+	stdout, stderr, err := s.ExecuteContext(ctx, cmds...)
+	goerr.Check(err)
+	return stdout, stderr
+}
+
+// ExecuteTimeout is the same as Execute but each command must complete within
+// the given duration, otherwise an error wrapping context.DeadlineExceeded is
+// returned.
+//
+// Just like ExecuteContext, once a command times out it is interrupted or, if
+// that fails, the underlying PowerShell process will be killed and you won't
+// be able to use this instance of the Shell any longer.
+//
+// Time spent waiting for commands from other goroutines to complete counts
+// towards the timeout. A command that times out before it is sent does not
+// kill the shell.
+func (s *Shell) ExecuteTimeout(d time.Duration, cmds ...string) (string, string, error) {
+	stdout := ""
+	stderr := ""
+
+	for _, cmd := range cmds {
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		o, e, err := s.ExecuteContext(ctx, cmd)
+		cancel()
+		stdout = stdout + o
+		stderr = stderr + e
+		if err != nil {
+			return stdout, stderr, err
+		}
+	}
+
+	return stdout, stderr, nil
+}
+
+// MustExecuteTimeout is the same as ExecuteTimeout but panics on error instead of returning an error.
+func (s *Shell) MustExecuteTimeout(d time.Duration, cmds ...string) (string, string) {
+	stdout, stderr, err := s.ExecuteTimeout(d, cmds...)
+	goerr.Check(err)
+	return stdout, stderr
+}
+
+func (s *Shell) execute(ctx context.Context, cmd string) (string, string, error) {
+	var stdout, stderr strings.Builder
+	err := s.run(ctx, cmd,
+		func(line string) { stdout.WriteString(line) },
+		func(line string) { stderr.WriteString(line) },
+	)
+
+	var runtimeErr *RuntimeError
+	if errors.As(err, &runtimeErr) {
+		runtimeErr.Stderr = stderr.String()
+	}
+	return stdout.String(), stderr.String(), err
+}
+
+// run sends a single command to the PowerShell process & then calls onStdout
+// and onStderr with each line of output, including the line ending, as it is
+// read from the respective pipe.
+func (s *Shell) run(ctx context.Context, cmd string, onStdout, onStderr func(string)) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.backend == nil {
+		if s.idleClosed {
+			return goerr.Wrap(&ShellClosedError{
+				Reason: fmt.Sprintf("Cannot execute commands on a shell that was idle for %s", s.idleTimeout),
+				Err:    ErrIdleClosed,
+			}, cmd)
+		}
+		return goerr.Wrap(&ShellClosedError{Reason: "Cannot execute commands on closed shells."}, cmd)
+	}
+	defer s.touch()
+
+	if err := ctx.Err(); err != nil {
+		return goerr.Wrap(err, "Command was cancelled before it was sent", cmd)
+	}
+
+	if s.dryRun {
+		outBoundary, errBoundary, err := s.newBoundaries()
+		if err != nil {
+			return err
+		}
+		onStdout(composeCommand(cmd, outBoundary, errBoundary, s.lineEnding, s.autoTryCatch))
+		return nil
+	}
+
+	if !isQuiet(ctx) {
+		s.record(redactSecret(ctx, cmd))
+	}
+
+	if s.metrics != nil {
+		var finish func(error)
+		onStdout, onStderr, finish = s.measure(redactSecret(ctx, cmd), onStdout, onStderr)
+		defer func() { finish(redactError(ctx, err)) }()
+	}
+
+	// So that Interrupt can cancel the command
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancelMu.Lock()
+	s.cancel = cancel
+	s.cancelMu.Unlock()
+	defer func() {
+		s.cancelMu.Lock()
+		s.cancel = nil
+		s.cancelMu.Unlock()
+		cancel()
+	}()
+
+	onStdout = tee(s.teeStdout, onStdout)
+	onStderr = tee(s.teeStderr, onStderr)
+	if s.stripANSI {
+		onStdout = stripANSI(onStdout)
+		onStderr = stripANSI(onStderr)
+	}
+
+	if s.healthCheck {
+		if err := s.ping(ctx); err != nil {
+			if !s.autoRestart || ctx.Err() != nil {
+				return goerr.Wrap(err, "Health check failed before sending", cmd)
+			}
+			if err := s.restart(); err != nil {
+				return goerr.Wrap(err, "Failed to restart PowerShell before sending", cmd)
+			}
+		}
+	}
+
+	crashed, err := s.runLocked(ctx, cmd, onStdout, onStderr)
+	if crashed && s.autoRestart {
+		if rerr := s.restart(); rerr != nil {
+			return goerr.Wrap(rerr, "Failed to restart PowerShell after", err.Error())
+		}
+		_, err = s.runLocked(ctx, cmd, onStdout, onStderr)
+	}
+	return err
+}
+
+// runLocked does the actual work of run, it must be called with the lock held.
+//
+// crashed is true when the command failed because the PowerShell process could
+// no longer be written to or read from, most likely because it has exited.
+func (s *Shell) runLocked(ctx context.Context, cmd string, onStdout, onStderr func(string)) (crashed bool, err error) {
+	// Wrap the command in special markers so we know when to stop reading from the pipes.
+	// The exit code & status of the command is written on the same line as the
+	// stdout marker so it is captured atomically with the rest of the output.
+	//
+	// A terminating error would stop PowerShell from running the rest of the
+	// line, ie: the markers, so the command is also wrapped in a try/catch.
+	//
+	// The markers are split in half in the command text so that anything that
+	// echoes the command back to us (eg: error messages, Set-PSDebug -Trace)
+	// can never be mistaken for the marker itself.
+	outBoundary, errBoundary, err := s.newBoundaries()
+	if err != nil {
+		return false, err
+	}
+	outMarker := &marker{boundary: outBoundary, trailer: statusTrailer}
+	errMarker := &marker{boundary: errBoundary, trailer: emptyTrailer}
+	full := composeCommand(cmd, outMarker.boundary, errMarker.boundary, s.lineEnding, s.autoTryCatch)
+
+	sendCtx := ctx
+	var stalled int32
+	if s.promptTimeout > 0 {
+		var cancel context.CancelFunc
+		sendCtx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go s.watchOutput(sendCtx, cancel, &stalled, s.stdout, s.stderr)
+	}
+
+	trailer, err := s.send(sendCtx, cmd, full, outMarker, errMarker, s.fatalErrors, onStdout, onStderr)
+	if err != nil {
+		if atomic.LoadInt32(&stalled) == 1 && ctx.Err() == nil {
+			if !s.interrupt() {
+				s.kill()
+			}
+			return false, goerr.Wrap(ErrNoOutput, fmt.Sprintf("Command was aborted after %s", s.promptTimeout), cmd)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if !s.interrupt() {
+				s.kill()
+			}
+			return false, goerr.Wrap(ctxErr, "Command was cancelled", cmd)
+		}
+		var parserErr *ParserError
+		if errors.As(err, &parserErr) {
+			if !s.recoverable {
+				s.exit(s.exitTimeout)
+			} else if rerr := s.resync(ctx); rerr != nil {
+				s.kill()
+				return false, goerr.Wrap(rerr, "Failed to recover from", err.Error())
+			}
+			return false, goerr.Wrap(err, "Failed to read stdout/stderr steams")
+		}
+		// The process is most likely dead, there's no point keeping it around
+		s.kill()
+		return true, goerr.Wrap(&ShellClosedError{Reason: "PowerShell exited unexpectedly", Err: err}, "Failed to read stdout/stderr steams")
+	}
+
+	status := strings.Fields(trailer)
+	if len(status) != 2 && !(len(status) == 3 && s.autoTryCatch) {
+		return false, goerr.Wrap("Failed to parse the command status", trailer)
+	}
+
+	exitCode, err := strconv.Atoi(status[0])
+	if err != nil {
+		return false, goerr.Wrap(err, "Failed to parse the exit code", status[0])
+	}
+	s.exitCode = exitCode
+	s.exitCodeSet = true
 
-					output = output + string(buf[:read])
+	switch status[1] {
+	case "Terminated":
+		runtimeErr := &RuntimeError{Terminating: true, ExitCode: exitCode}
+		if len(status) == 3 {
+			parseErrorDetail(status[2], runtimeErr)
+		}
+		return false, goerr.Wrap(runtimeErr, cmd)
+	case "Failed":
+		if s.errorStop {
+			return false, goerr.Wrap(&RuntimeError{ExitCode: exitCode}, cmd)
+		}
+	}
 
-					if strings.HasSuffix(output, marker) {
-						break
-					}
-				}
-			}),
-		)
+	return false, nil
+}
+
+// interrupt stops a command that was cancelled part way through, with the
+// Interrupter, & then resyncs the streams. false is returned if the backend
+// is not an Interrupter or PowerShell did not respond in time, in which case
+// the process must be killed instead.
+func (s *Shell) interrupt() bool {
+	if s.interrupter == nil {
+		return false
+	}
+	if err := s.interrupter.Interrupt(); err != nil {
+		return false
+	}
+
+	// The command was stopped part way through so it's markers will never
+	// arrive, once the resync markers do we know PowerShell is ready again.
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	return s.resync(ctx) == nil
+}
+
+// ComposeCommand returns exactly what is written to PowerShell's STDIN to
+// execute cmd, given the boundaries that mark the end of it's output on
+// STDOUT & STDERR. Useful for debugging & testing code that generates
+// commands, also see DryRun.
+//
+// The boundaries are usually random, eg: "$gopwsh" followed by 24 hex
+// characters & another "$".
+//
+// Commands are terminated with the line ending of the OS this was compiled
+// for, whereas a Shell uses the line ending of it's backend, see LineEnding.
+func ComposeCommand(cmd, outBoundary, errBoundary string) string {
+	return composeCommand(cmd, outBoundary, errBoundary, newLine, false)
+}
+
+func composeCommand(cmd, outBoundary, errBoundary, newLine string, tryCatch bool) string {
+	outMarker := &marker{boundary: outBoundary}
+	errMarker := &marker{boundary: errBoundary}
+	init, catch, detail := "", "", ""
+	if tryCatch {
+		init, catch, detail = "$gopwshDetail = ''; ", errorDetailCmd, " + $gopwshDetail"
+	}
+	usings, cmd := hoistUsing(cmd, newLine)
+
+	// The command is on it's own lines so that a trailing comment can't
+	// swallow the rest of the wrapper.
+	full := fmt.Sprintf("%s$global:LASTEXITCODE = 0; $gopwshStatus = 'Failed'; %s"+
+		"try {%s%s%s; if ($?) { $gopwshStatus = 'Ok' } } "+
+		"catch { $gopwshStatus = 'Terminated'; %s[Console]::Error.WriteLine(($_ | Out-String)) }; "+
+		"echo (%s + ' ' + $global:LASTEXITCODE + ' ' + $gopwshStatus%s); [Console]::Error.WriteLine(%s)%s",
+		usings, init, newLine, cmd, newLine, catch, outMarker.literal(), detail, errMarker.literal(), newLine,
+	)
+
+	// PowerShell only knows that a statement spanning multiple lines is
+	// complete once it reads an empty line.
+	return full + newLine
+}
+
+// usingStatement matches a line that starts with a using statement.
+var usingStatement = regexp.MustCompile(`(?i)^\s*using\s+(namespace|module|assembly)\s`)
+
+// hoistUsing splits any using statements, on their own lines at the start of
+// cmd, from the rest of it. PowerShell only allows them before any other
+// statement so they can't be inside the try block.
+func hoistUsing(cmd, newLine string) (string, string) {
+	lines := strings.SplitAfter(cmd, "\n")
+	i := 0
+	for i < len(lines) && (strings.TrimSpace(lines[i]) == "" || usingStatement.MatchString(lines[i])) {
+		i++
+	}
+
+	usings := strings.TrimRight(strings.Join(lines[:i], ""), "\r\n")
+	if usings != "" {
+		usings = usings + newLine
+	}
+	return usings, strings.Join(lines[i:], "")
+}
+
+// send writes the full command to the PowerShell process & then reads both
+// streams until the markers are found, returning the stdout marker's trailer.
+// cmd is only used for logging.
+//
+// Both readers are always stopped before send returns, even when the other
+// one fails, so that an abandoned reader can never steal any of the output
+// of the next command.
+func (s *Shell) send(ctx context.Context, cmd, full string, outMarker, errMarker *marker, fatalErrors []string, onStdout, onStderr func(string)) (string, error) {
+	cmd = redactSecret(ctx, cmd)
+	if !isQuiet(ctx) {
+		s.log(LogSent, cmd, redactSecret(ctx, full))
+		onStdout = s.logLines(LogStdout, cmd, onStdout)
+		onStderr = s.logLines(LogStderr, cmd, onStderr)
+	}
+	onStdout = redactLines(ctx, onStdout)
+	onStderr = redactLines(ctx, onStderr)
+	err := s.write(full)
+	if err != nil {
+		return "", goerr.Wrap(err, "Could not send PowerShell command")
+	}
+
+	// STDERR closing cleanly before it's marker is seen is treated as the end
+	// of it's output, only the marker on STDOUT has to arrive. Whereas if
+	// STDOUT closes the process is gone & waiting on STDERR is abandoned.
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var out, errs readResult
+	wg.Add(2)
+	_, err = await.FastAllOrError(
+		streamReader(readCtx, &wg, &out, s.stdout, outMarker, fatalErrors, false, onStdout),
+		streamReader(readCtx, &wg, &errs, s.stderr, errMarker, fatalErrors, true, onStderr),
+	)
+	cancel()
+	wg.Wait()
+
+	// FastAllOrError can see a task as done before it sees the task's result,
+	// so the results recorded by the readers themselves are the ones used.
+	if err == nil {
+		err = out.err
+	}
+	if err == nil {
+		err = errs.err
+	}
+	if err != nil {
+		return "", err
+	}
+	return out.trailer, nil
+}
+
+// writeChunkSize is the most that is written to PowerShell's STDIN at once.
+const writeChunkSize = 64 * 1024
+
+// write writes to PowerShell's STDIN, flushing it if the backend buffers.
+//
+// Large commands are written in chunks & every write is checked for being
+// short, so a command is either written in full or an error is returned.
+// Backends that implement Framer are given the whole command at once.
+func (s *Shell) write(v string) error {
+	if f, ok := s.backend.(Framer); ok {
+		return f.WriteFrame([]byte(v))
+	}
+
+	stdin := s.backend.Stdin()
+	b := []byte(v)
+	for len(b) > 0 {
+		n := len(b)
+		if n > writeChunkSize {
+			n = writeChunkSize
+		}
+		written, err := stdin.Write(b[:n])
 		if err != nil {
+			return err
+		}
+		if written == 0 {
+			return io.ErrShortWrite
+		}
+		b = b[written:]
+	}
+	if flusher, ok := stdin.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// resync is used to recover after a fatal error. Markers are sent on their own
+// & everything read before them is discarded, once found we know that both
+// streams are back in sync with the commands we send.
+//
+// The markers are preceded by an empty line so that PowerShell will give up on
+// any multi-line statement that it may still be waiting to see the end of.
+func (s *Shell) resync(ctx context.Context) error {
+	outBoundary, errBoundary, err := s.newBoundaries()
+	if err != nil {
+		return err
+	}
+	outMarker := &marker{boundary: outBoundary, trailer: emptyTrailer}
+	errMarker := &marker{boundary: errBoundary, trailer: emptyTrailer}
+	full := fmt.Sprintf("%secho (%s); [Console]::Error.WriteLine(%s)%s",
+		s.lineEnding, outMarker.literal(), errMarker.literal(), s.lineEnding,
+	)
+
+	discard := func(string) {}
+	_, err = s.send(ctx, "", full, outMarker, errMarker, nil, discard, discard)
+	return err
+}
+
+// pingTimeout is how long Ping waits for PowerShell to respond.
+const pingTimeout = 5 * time.Second
+
+// Ping checks that the PowerShell process is still alive & responding.
+//
+// If PowerShell does not respond within a few seconds the process is killed &
+// an error is returned, the Shell can not be used any longer & a new one
+// should be created.
+//
+// If a command is currently executing, Ping will wait for it to complete.
+func (s *Shell) Ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.backend == nil {
+		return goerr.New("Cannot ping closed shells.")
+	}
+	return s.ping(context.Background())
+}
+
+// ping sends a pair of markers without any command, much like resync, & waits
+// for them to be echoed back.
+func (s *Shell) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	if err := s.resync(ctx); err != nil {
+		s.kill()
+		return goerr.Wrap(err, "PowerShell is not responding")
+	}
+	return nil
+}
+
+// ExecuteStream is like Execute but instead of buffering all the output & only
+// returning once the command has completed, onStdout & onStderr are called
+// as soon as each line of output is read, without the line ending.
+//
+// Either callback may be nil if you are not interested in that stream.
+//
+// Output that does not end with a new line (eg: Write-Host -NoNewline) will
+// only be passed to a callback once the command has completed.
+func (s *Shell) ExecuteStream(cmd string, onStdout func(string), onStderr func(string)) error {
+	err := s.run(context.Background(), cmd, lineCallback(onStdout), lineCallback(onStderr))
+	if err != nil {
+		return goerr.Wrap(err, "failed to execute", cmd)
+	}
+	return nil
+}
+
+// MustExecuteStream is the same as ExecuteStream but panics on error instead of returning an error.
+func (s *Shell) MustExecuteStream(cmd string, onStdout func(string), onStderr func(string)) {
+	goerr.Check(s.ExecuteStream(cmd, onStdout, onStderr))
+}
+
+func lineCallback(fn func(string)) func(string) {
+	return func(line string) {
+		if fn != nil {
+			fn(strings.TrimRight(line, "\r\n"))
+		}
+	}
+}
+
+// LastExitCode returns the value of $LASTEXITCODE as captured at the end of
+// the most recently executed command.
+//
+// $LASTEXITCODE is reset to 0 before each command is executed so a non-zero
+// value always belongs to the last command & never one executed before it.
+//
+// An error is returned if no command has been executed yet.
+func (s *Shell) LastExitCode() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.exitCodeSet {
+		return 0, goerr.New("No command has been executed yet")
+	}
+	return s.exitCode, nil
+}
+
+// PID returns the OS process id of the PowerShell process.
+//
+// Not all backends are able to provide a PID, for example a remote process
+// started over SSH, in which case an error is returned.
+//
+// PID does not wait for an executing command to complete, so it can be used to
+// monitor a long running command. Keep in mind that once the shell has exited
+// the PID may be reused by the OS for some other process.
+func (s *Shell) PID() (int, error) {
+	s.pidMu.Lock()
+	defer s.pidMu.Unlock()
+
+	if s.pid == 0 {
+		return 0, goerr.New("The backend does not support getting the PID")
+	}
+	return s.pid, nil
+}
+
+// LookPath asks the backend to find an executable, so for remote backends,
+// eg: SSH or Docker, the path is resolved on the remote host. An error that
+// wraps backend.ErrNotFound is returned if it can't be found.
+//
+// LookPath does not wait for an executing command to complete & can be used
+// after the shell has exited.
+func (s *Shell) LookPath(file string) (string, error) {
+	path, err := s.starter.LookPath(file)
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to look up", file)
+	}
+	return path, nil
+}
+
+// Exit is used to kill the powershell process.
+//
+// Typical usage might look like:
+// 	shell := gopwsh.New()
+// 	defer shell.Exit()
+//
+// If a command is currently executing, Exit will wait for it to complete.
+//
+// The process is killed if it has not exited within the GracefulExitTimeout,
+// 10 seconds by default.
+func (s *Shell) Exit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exit(s.exitTimeout)
+}
+
+// ExitTimeout is the same as Exit but waits d, instead of the
+// GracefulExitTimeout, for the process to exit before it is killed. A d of 0
+// waits forever.
+func (s *Shell) ExitTimeout(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exit(d)
+}
+
+func (s *Shell) exit(timeout time.Duration) {
+	if s.backend == nil {
+		return
+	}
+	s.stopIdleTimer()
+
+	if s.transcript {
+		s.write("$null = Stop-Transcript" + s.lineEnding)
+	}
+	s.write("exit" + s.lineEnding)
+
+	// If it's possible to close stdin, do so.
+	// Some backends, like the local one, do support it.
+	if s.closeStdin {
+		closer, ok := s.backend.Stdin().(io.Closer)
+		if ok {
+			closer.Close()
+		}
+	}
+
+	if timeout <= 0 {
+		s.backend.Wait()
+	} else if w, ok := s.backend.(TimeoutWaiter); ok {
+		if err := w.WaitTimeout(timeout); errors.Is(err, backend.ErrWaitTimeout) {
+			if killer, ok := s.backend.(Killer); ok {
+				killer.Kill()
+				s.backend.Wait()
+			}
+		}
+	} else {
+		b := s.backend
+		exited := make(chan struct{})
+		go func() {
+			b.Wait()
+			close(exited)
+		}()
+
+		select {
+		case <-exited:
+		case <-time.After(timeout):
+			if killer, ok := b.(Killer); ok {
+				killer.Kill()
+				<-exited
+			}
+		}
+	}
+
+	s.stdout.close()
+	s.stderr.close()
+	s.backend = nil
+	s.exitInfo.finish(true, nil)
+	s.exitInfo = nil
+	atomic.StoreInt32(&s.alive, 0)
+	s.closed()
+}
+
+// IsClosed reports if the Shell can no longer be used, because it has been
+// exited or killed, by you or because of an error. It does not wait for any
+// executing command to complete.
+//
+// A Shell that is not closed may still have a PowerShell process that has
+// died without us noticing yet, use Ping to be sure.
+func (s *Shell) IsClosed() bool {
+	return atomic.LoadInt32(&s.alive) == 0
+}
+
+// Interrupt stops the command that is currently executing, like pressing
+// Ctrl+C in a console, without killing the PowerShell process. The command
+// returns an error & the Shell can then be used as normal. Nothing happens
+// if no command is executing.
+//
+// Cancelling the context given to ExecuteContext, or a timeout, does the same.
+// If PowerShell does not respond to the interrupt within a few seconds the
+// process is killed, just as it is for backends that do not implement
+// Interrupter, for which an error is returned.
+func (s *Shell) Interrupt() error {
+	if s.interrupter == nil {
+		return goerr.New("The backend does not support interrupting the PowerShell process")
+	}
+
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return nil
+}
+
+// Kill forcefully terminates the PowerShell process, unlike Exit it does not
+// wait for any executing command to complete. That command will return an
+// error & the Shell can not be used any longer.
+//
+// Use this as an escape hatch when a command is stuck, for example waiting on
+// Read-Host. An error is returned if the backend does not implement Killer.
+// Killing a shell that has already exited does nothing.
+func (s *Shell) Kill() error {
+	if s.killer == nil {
+		return goerr.New("The backend does not support killing the PowerShell process")
+	}
+
+	// Kill first, without the lock, so that any executing command fails fast
+	err := s.killer.Kill()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.backend == nil {
+		return nil
+	}
+	s.kill()
+
+	if err != nil {
+		return goerr.Wrap(err, "Failed to kill the PowerShell process")
+	}
+	return nil
+}
+
+// kill is used when the PowerShell process has been left in an unknown state,
+// for example when a command was cancelled part way through. There is no way
+// to re-synchronise with such a process so we don't bother asking it nicely
+// to exit, we just get rid of it & mark the shell as closed.
+func (s *Shell) kill() {
+	if s.backend == nil {
+		return
+	}
+	s.terminate()
+	s.closed()
+}
+
+// terminate does the work of kill, without calling closed, so that restart
+// can start a new process.
+func (s *Shell) terminate() {
+	if s.backend == nil {
+		return
+	}
+	s.stopIdleTimer()
+
+	s.stdout.close()
+	s.stderr.close()
+
+	if closer, ok := s.backend.Stdin().(io.Closer); ok {
+		closer.Close()
+	}
+
+	// Not all backends will be able to forcefully kill their process, in
+	// which case we wait for it in the background. Closing stdin above
+	// means it should exit once the current command does complete.
+	b, info := s.backend, s.exitInfo
+	if killer, ok := b.(Killer); ok {
+		killer.Kill()
+		info.finish(false, b.Wait())
+	} else {
+		go func() { info.finish(false, b.Wait()) }()
+	}
+
+	s.backend = nil
+	s.exitInfo = nil
+	atomic.StoreInt32(&s.alive, 0)
+}
+
+// closed is called once the Shell has been closed for good.
+func (s *Shell) closed() {
+	if s.onClose != nil {
+		s.onClose()
+	}
+}
+
+// stream continuously pumps the chunks read from one of the backend's output
+// pipes into a channel. This means reading the output of a single command can
+// be abandoned at any time without leaving a goroutine blocked on the pipe.
+type stream struct {
+	// lastRead is when, in unix nanoseconds, something was last read. It is
+	// first so that it is 64-bit aligned for atomic access on 32-bit platforms.
+	lastRead int64
+
+	// eof is closed once the pipe has been read to the end, or failed.
+	eof chan struct{}
+
+	chunks chan []byte
+	done   chan struct{}
+	once   sync.Once
+	err    error
+}
+
+func newStream(r io.Reader, bufferSize int) *stream {
+	st := &stream{
+		chunks: make(chan []byte),
+		done:   make(chan struct{}),
+		eof:    make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			buf := make([]byte, bufferSize)
+			read, err := r.Read(buf)
+			if read > 0 {
+				atomic.StoreInt64(&st.lastRead, time.Now().UnixNano())
+
+				// Once closed we keep on draining the pipe, otherwise the
+				// process may block writing to it & never be able to exit.
+				select {
+				case st.chunks <- buf[:read]:
+				case <-st.done:
+				}
+			}
+			if err != nil {
+				st.err = err
+				close(st.chunks)
+				close(st.eof)
+				return
+			}
+		}
+	}()
+
+	return st
+}
+
+func (st *stream) close() {
+	st.once.Do(func() { close(st.done) })
+}
+
+// drain collects anything else that arrives on the stream within d.
+func (st *stream) drain(d time.Duration) string {
+	var output strings.Builder
+	timeout := time.After(d)
+	for {
+		select {
+		case chunk, ok := <-st.chunks:
+			if !ok {
+				return output.String()
+			}
+			output.Write(chunk)
+		case <-timeout:
+			return output.String()
+		}
+	}
+}
+
+var (
+	// statusTrailer is written after the stdout marker, eg: "0 Ok", followed
+	// by the base64 encoded details of a terminating error with AutoTryCatch
+	statusTrailer = regexp.MustCompile(`^ -?\d+ (Ok|Failed|Terminated)( [A-Za-z0-9+/=]+)?\r?\n$`)
+
+	// emptyTrailer means nothing but the line ending may follow a marker
+	emptyTrailer = regexp.MustCompile(`^\r?\n$`)
+)
+
+// marker is written to a stream once a command has completed so that we know
+// where the output of the command ends.
+type marker struct {
+	boundary string
+	trailer  *regexp.Regexp
+}
+
+// literal returns a PowerShell expression that evaluates to the boundary,
+// without the boundary itself appearing verbatim in the command text.
+func (m *marker) literal() string {
+	half := len(m.boundary) / 2
+	return QuoteArg(m.boundary[:half]) + " + " + QuoteArg(m.boundary[half:])
+}
+
+// match looks for the marker in a line of output, returning the text written
+// before the marker (output that did not end with a new line) & the text
+// written after it.
+//
+// A line only matches when the boundary is followed by the expected trailer,
+// so any other output that just happens to contain the boundary is ignored.
+func (m *marker) match(line []byte) ([]byte, string, bool) {
+	j := bytes.LastIndex(line, []byte(m.boundary))
+	if j == -1 {
+		return nil, "", false
+	}
+
+	trailer := line[j+len(m.boundary):]
+	if !m.trailer.Match(trailer) {
+		return nil, "", false
+	}
+
+	return line[:j], string(bytes.TrimSpace(trailer)), true
+}
+
+// readResult is what a streamReader found, it is recorded before the task
+// resolves or rejects.
+type readResult struct {
+	trailer string
+	err     error
+}
+
+// streamReader reads from the stream, calling write with each line, until it
+// finds the marker. It resolves with anything else written after the marker
+// on the same line.
+//
+// The output is checked for fatal errors as it is read, for example a
+// ParserError means the marker will never arrive.
+func streamReader(ctx context.Context, wg *sync.WaitGroup, result *readResult, st *stream, m *marker, fatalErrors []string, eofComplete bool, write func(string)) *task.Task {
+	return task.New(func(t *task.Internal) {
+		defer wg.Done()
+
+		reject := func(err error) {
+			result.err = err
 			t.Reject(err)
-			return
 		}
 
-		t.Resolve(strings.TrimSuffix(output, marker))
+		// pending holds any incomplete line, searched is how much of it is
+		// known not to contain a line ending so a very long line is only
+		// ever scanned once.
+		var pending []byte
+		searched := 0
+
+		for {
+			select {
+			case <-ctx.Done():
+				reject(goerr.Wrap(ctx.Err(), "stopped reading stream"))
+				return
+			case chunk, ok := <-st.chunks:
+				if !ok {
+					if eofComplete && st.err == io.EOF {
+						// Closed cleanly, there is nothing more to wait for
+						if len(pending) > 0 {
+							write(string(pending))
+						}
+						t.Resolve("")
+						return
+					}
+					reject(goerr.Wrap(st.err, "failed to read stream"))
+					return
+				}
+				pending = append(pending, chunk...)
+			}
+
+			for {
+				i := bytes.IndexByte(pending[searched:], '\n')
+				if i == -1 {
+					searched = len(pending)
+					break
+				}
+				raw := pending[:searched+i+1]
+				line := string(raw)
+				pending = pending[searched+i+1:]
+				searched = 0
+
+				if pattern, ok := containsAny(line, fatalErrors); ok {
+					// Give PowerShell a moment to finish writing the error
+					reject(&ParserError{
+						Pattern: pattern,
+						Output:  line + string(pending) + st.drain(time.Millisecond*10),
+					})
+					return
+				}
+
+				if prefix, trailer, ok := m.match(raw); ok {
+					if len(prefix) > 0 {
+						write(string(prefix))
+					}
+					result.trailer = trailer
+					t.Resolve(trailer)
+					return
+				}
+
+				write(line)
+			}
+		}
 	})
 }
 
+// containsAny returns the first of substrs that is found in s.
+func containsAny(s string, substrs []string) (string, bool) {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return substr, true
+		}
+	}
+	return "", false
+}
+
 func createBoundary() string {
 	return "$gopwsh" + randstr.Hex(12) + "$"
 }
+
+// boundaryRandMu serialises reads from every BoundaryRand source.
+var boundaryRandMu sync.Mutex
+
+// createBoundaryFrom is the same as createBoundary but reads from r.
+func createBoundaryFrom(r io.Reader) (string, error) {
+	boundaryRandMu.Lock()
+	defer boundaryRandMu.Unlock()
+
+	b := make([]byte, 12)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", goerr.Wrap(err, "failed to read from the BoundaryRand source")
+	}
+	return "$gopwsh" + hex.EncodeToString(b) + "$", nil
+}
+
+// newBoundary creates a boundary with the BoundaryFunc or BoundaryRand, if
+// either was given.
+func (s *Shell) newBoundary() (string, error) {
+	if s.boundaryFunc == nil {
+		if s.boundaryRand != nil {
+			return createBoundaryFrom(s.boundaryRand)
+		}
+		return createBoundary(), nil
+	}
+
+	boundary := s.boundaryFunc()
+	if boundary == "" {
+		return "", goerr.New("BoundaryFunc returned an empty boundary")
+	}
+	if strings.ContainsAny(boundary, "\r\n") {
+		return "", goerr.Wrap("BoundaryFunc returned a boundary containing a line ending", boundary)
+	}
+	return boundary, nil
+}
+
+// newBoundaries creates a boundary for each of STDOUT & STDERR.
+func (s *Shell) newBoundaries() (string, string, error) {
+	outBoundary, err := s.newBoundary()
+	if err != nil {
+		return "", "", err
+	}
+	errBoundary, err := s.newBoundary()
+	if err != nil {
+		return "", "", err
+	}
+	return outBoundary, errBoundary, nil
+}
@@ -0,0 +1,408 @@
+package gopwsh
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/brad-jones/gopwsh/backend"
+)
+
+// newMockShell starts a Shell backed by b that is exited once the test ends.
+func newMockShell(t *testing.T, b *backend.Mock, decorators ...func(*Shell) error) *Shell {
+	t.Helper()
+	s, err := New(append([]func(*Shell) error{Backend(b)}, decorators...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Exit)
+	return s
+}
+
+func TestExecuteConcurrently(t *testing.T) {
+	b := backend.NewMock()
+	for i := 0; i < 50; i++ {
+		b.Expect(fmt.Sprintf("Write-Output %d", i), fmt.Sprintf("%d\n", i), fmt.Sprintf("err %d\n", i))
+	}
+	s := newMockShell(t, b)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stdout, stderr, err := s.Execute(fmt.Sprintf("Write-Output %d", i))
+			if err != nil {
+				t.Errorf("command %d failed: %v", i, err)
+				return
+			}
+			if want := fmt.Sprintf("%d\n", i); stdout != want {
+				t.Errorf("command %d got stdout %q, want %q", i, stdout, want)
+			}
+			if want := fmt.Sprintf("err %d\n", i); stderr != want {
+				t.Errorf("command %d got stderr %q, want %q", i, stderr, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestExecuteOutputContainingTheBoundary(t *testing.T) {
+	const boundary = "$gopwshtest$"
+	// Each stream is given the lines that would end the other stream's output
+	stdoutWant := "before\n" + boundary + "\n" + boundary + " done\nafter\n"
+	stderrWant := "before\n" + boundary + " 0 Ok\n" + boundary + " done\nafter\n"
+
+	b := backend.NewMock().Expect("Get-Content log.txt", stdoutWant, stderrWant)
+	s := newMockShell(t, b, BoundaryFunc(func() string { return boundary }))
+
+	stdout, stderr, err := s.Execute("Get-Content log.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != stdoutWant {
+		t.Errorf("got stdout %q, want %q", stdout, stdoutWant)
+	}
+	if stderr != stderrWant {
+		t.Errorf("got stderr %q, want %q", stderr, stderrWant)
+	}
+}
+
+func TestMarkerMatch(t *testing.T) {
+	const boundary = "$gopwshtest$"
+
+	tests := []struct {
+		name    string
+		trailer *regexp.Regexp
+		line    string
+		before  string
+		after   string
+		ok      bool
+	}{
+		{"status", statusTrailer, boundary + " 0 Ok\n", "", "0 Ok", true},
+		{"status crlf", statusTrailer, boundary + " 1 Failed\r\n", "", "1 Failed", true},
+		{"status with detail", statusTrailer, boundary + " -1 Terminated YWJj\n", "", "-1 Terminated YWJj", true},
+		{"output before", statusTrailer, "partial" + boundary + " 0 Ok\n", "partial", "0 Ok", true},
+		{"boundary in output before", statusTrailer, boundary + " " + boundary + " 0 Ok\n", boundary + " ", "0 Ok", true},
+		{"status missing", statusTrailer, boundary + "\n", "", "", false},
+		{"unknown status", statusTrailer, boundary + " 0 Done\n", "", "", false},
+		{"text after", statusTrailer, boundary + " 0 Ok and more\n", "", "", false},
+		{"no line ending", statusTrailer, boundary + " 0 Ok", "", "", false},
+		{"no boundary", statusTrailer, "0 Ok\n", "", "", false},
+		{"empty", emptyTrailer, boundary + "\n", "", "", true},
+		{"empty output before", emptyTrailer, "partial" + boundary + "\r\n", "partial", "", true},
+		{"empty text after", emptyTrailer, boundary + " 0 Ok\n", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &marker{boundary: boundary, trailer: tt.trailer}
+			before, after, ok := m.match([]byte(tt.line))
+			if ok != tt.ok || string(before) != tt.before || after != tt.after {
+				t.Errorf("match(%q) = %q, %q, %v, want %q, %q, %v", tt.line, before, after, ok, tt.before, tt.after, tt.ok)
+			}
+		})
+	}
+}
+
+func TestOutputEncoding(t *testing.T) {
+	tests := []struct {
+		name       string
+		decorators []func(*Shell) error
+		want       string
+	}{
+		{"default", nil, "[Console]::OutputEncoding = (New-Object System.Text.UTF8Encoding $false); $OutputEncoding = (New-Object System.Text.UTF8Encoding $false)"},
+		{"utf8", []func(*Shell) error{OutputEncoding("UTF8")}, "[Console]::OutputEncoding = (New-Object System.Text.UTF8Encoding $false); $OutputEncoding = (New-Object System.Text.UTF8Encoding $false)"},
+		{"code page", []func(*Shell) error{OutputEncoding("windows-1252")}, "[Console]::OutputEncoding = [System.Text.Encoding]::GetEncoding('windows-1252'); $OutputEncoding = [System.Text.Encoding]::GetEncoding('windows-1252')"},
+		{"disabled", []func(*Shell) error{OutputEncoding("")}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := backend.NewMock()
+			s := newMockShell(t, b, tt.decorators...)
+			if _, _, err := s.Execute("Write-Output 'héllo 🌍'"); err != nil {
+				t.Fatal(err)
+			}
+
+			// The encoding must be set before any command of ours is executed
+			commands := b.Commands()
+			first := -1
+			for i, cmd := range commands {
+				if cmd == "Write-Output 'héllo 🌍'" {
+					first = i
+					break
+				}
+			}
+			if first < 0 {
+				t.Fatalf("command was never sent: %q", commands)
+			}
+			got := ""
+			for _, cmd := range commands[:first] {
+				if strings.Contains(cmd, "OutputEncoding") {
+					got = cmd
+				}
+			}
+			if got != tt.want {
+				t.Errorf("got startup command %q, want %q in %q", got, tt.want, commands)
+			}
+		})
+	}
+}
+
+// TestExecuteNonASCII needs a real PowerShell, unlike the Mock it does not
+// simply echo back whatever it is given.
+func TestExecuteNonASCII(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a real PowerShell process")
+	}
+	if _, err := exec.LookPath("pwsh"); err != nil {
+		t.Skip("pwsh is not installed")
+	}
+
+	const text = "héllo wörld, こんにちは 🌍"
+
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Exit()
+
+	stdout, stderr, err := s.Execute("Write-Output " + QuoteArg(text) + "; [Console]::Error.WriteLine(" + QuoteArg(text) + ")")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(stdout) != text {
+		t.Errorf("got stdout %q, want %q", stdout, text)
+	}
+	if strings.TrimSpace(stderr) != text {
+		t.Errorf("got stderr %q, want %q", stderr, text)
+	}
+}
+
+func TestExecuteLargeOutput(t *testing.T) {
+	lines := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 50000)
+	long := strings.Repeat("x", 1<<20)
+
+	tests := []struct {
+		name       string
+		decorators []func(*Shell) error
+	}{
+		{"default buffer", nil},
+		{"small buffer", []func(*Shell) error{BufferSize(64)}},
+		{"odd buffer", []func(*Shell) error{BufferSize(4093)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := backend.NewMock().
+				Expect("Get-Lines", lines, lines).
+				Expect("Get-LongLine", long, long)
+			s := newMockShell(t, b, tt.decorators...)
+
+			for cmd, want := range map[string]string{"Get-Lines": lines, "Get-LongLine": long} {
+				stdout, stderr, err := s.Execute(cmd)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stdout != want {
+					t.Errorf("%s got %d bytes of stdout, want %d", cmd, len(stdout), len(want))
+				}
+				if stderr != want {
+					t.Errorf("%s got %d bytes of stderr, want %d", cmd, len(stderr), len(want))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkExecuteLargeOutput(b *testing.B) {
+	output := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 10<<20/44)
+
+	m := backend.NewMock().Expect("Get-Lines", output, "")
+	s, err := New(Backend(m))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Exit()
+
+	b.SetBytes(int64(len(output)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := s.Execute("Get-Lines"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// earlyEOF is a Mock whose STDOUT or STDERR ends, after the given output, as
+// soon as the process starts. Like a process that exits before it's markers
+// are flushed.
+type earlyEOF struct {
+	*backend.Mock
+	stdout *string
+	stderr *string
+}
+
+func (b *earlyEOF) StartProcess(cmd string, args ...string) error {
+	if err := b.Mock.StartProcess(cmd, args...); err != nil {
+		return err
+	}
+	// The Mock blocks until everything it writes has been read
+	if b.stdout != nil {
+		go io.Copy(io.Discard, b.Mock.Stdout())
+	}
+	if b.stderr != nil {
+		go io.Copy(io.Discard, b.Mock.Stderr())
+	}
+	return nil
+}
+
+func (b *earlyEOF) Stdout() io.Reader {
+	if b.stdout != nil {
+		return strings.NewReader(*b.stdout)
+	}
+	return b.Mock.Stdout()
+}
+
+func (b *earlyEOF) Stderr() io.Reader {
+	if b.stderr != nil {
+		return strings.NewReader(*b.stderr)
+	}
+	return b.Mock.Stderr()
+}
+
+func TestExecuteStderrClosed(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+	}{
+		{"empty", ""},
+		{"complete line", "warning\n"},
+		{"partial line", "warning"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &earlyEOF{Mock: backend.NewMock().Expect("Get-Date", "Monday\n", "ignored\n"), stderr: &tt.stderr}
+			s, err := New(Backend(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Exit()
+
+			// Anything written before STDERR closed is read as New waits for
+			// PowerShell to become ready
+			for i := 0; i < 2; i++ {
+				stdout, stderr, err := s.Execute("Get-Date")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stdout != "Monday\n" {
+					t.Errorf("got stdout %q, want %q", stdout, "Monday\n")
+				}
+				if stderr != "" {
+					t.Errorf("got stderr %q, want nothing", stderr)
+				}
+			}
+		})
+	}
+}
+
+func TestNewStdoutClosed(t *testing.T) {
+	stdout := "partial"
+	b := &earlyEOF{Mock: backend.NewMock(), stdout: &stdout}
+	if s, err := New(Backend(b)); err == nil {
+		s.Exit()
+		t.Error("expected an error once STDOUT has closed")
+	}
+}
+
+func TestExecuteControlCharacters(t *testing.T) {
+	tests := []struct {
+		name     string
+		boundary string
+	}{
+		{"quotes", "$gopwsh'it''s’‘$"},
+		{"control characters", "$gopwsh\x01\t\x1b$"},
+		{"escapes", "$gopwsh`\"$([char]0x41)$"},
+	}
+
+	cmd := "Write-Output " + QuoteArg("line1\nline2")
+	if strings.ContainsAny(cmd, "\r\n") {
+		t.Fatalf("command %q is not on a single line", cmd)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The Mock unquotes the literals that make up each marker
+			b := backend.NewMock().Expect(cmd, "line1\nline2\n", "")
+			s := newMockShell(t, b, BoundaryFunc(func() string { return tt.boundary }), AutoTryCatch(true))
+
+			stdout, _, err := s.Execute(cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stdout != "line1\nline2\n" {
+				t.Errorf("got stdout %q, want %q", stdout, "line1\nline2\n")
+			}
+		})
+	}
+}
+
+// shortWrites is a Mock whose STDIN accepts at most max bytes per write.
+type shortWrites struct {
+	*backend.Mock
+	max int
+}
+
+func (b *shortWrites) Stdin() io.Writer {
+	return &shortWriter{w: b.Mock.Stdin(), max: b.max}
+}
+
+type shortWriter struct {
+	w   io.Writer
+	max int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		p = p[:w.max]
+	}
+	return w.w.Write(p)
+}
+
+func TestExecuteLargeCommand(t *testing.T) {
+	cmd := "$script = '" + strings.Repeat("a", 5<<20) + "'\nWrite-Output $script.Length"
+
+	tests := []struct {
+		name    string
+		backend func(*backend.Mock) Starter
+	}{
+		{"full writes", func(m *backend.Mock) Starter { return m }},
+		{"short writes", func(m *backend.Mock) Starter { return &shortWrites{Mock: m, max: 4000} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := backend.NewMock().Expect(cmd, "5242880\n", "")
+			s, err := New(Backend(tt.backend(m)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Exit()
+
+			stdout, _, err := s.Execute(cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stdout != "5242880\n" {
+				t.Errorf("got stdout %q, the command was not received intact", stdout)
+			}
+		})
+	}
+}
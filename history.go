@@ -0,0 +1,67 @@
+package gopwsh
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// MaxHistory sets how many of the most recent commands are kept by History,
+// 0 disables the history altogether.
+//
+// Defaults to 100.
+func MaxHistory(n int) func(*Shell) error {
+	return func(s *Shell) error {
+		if n < 0 {
+			return goerr.New(fmt.Sprintf("MaxHistory can not be negative, got %d", n))
+		}
+		s.maxHistory = n
+		return nil
+	}
+}
+
+// History returns the commands sent to PowerShell so far, oldest first, eg:
+// to reproduce a failure by replaying the commands that led to it.
+//
+// The commands are as given to Execute & friends, without the markers that
+// are added to every command. Methods such as ExecuteJSON wrap the command
+// before sending it & that is what is recorded. Anything that gopwsh sends on
+// it's own behalf, eg: a Ping or each chunk of the input sent by Pipe, is not
+// recorded & the password given to SetCredential is redacted.
+//
+// History does not wait for an executing command to complete, which has
+// already been recorded.
+func (s *Shell) History() []string {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	return append([]string{}, s.history...)
+}
+
+// quietKey is the context key for commands that are neither recorded in the
+// history nor logged, eg: each chunk of the input sent by Pipe.
+type quietKey struct{}
+
+func quiet(ctx context.Context) context.Context {
+	return context.WithValue(ctx, quietKey{}, true)
+}
+
+func isQuiet(ctx context.Context) bool {
+	v, _ := ctx.Value(quietKey{}).(bool)
+	return v
+}
+
+// record adds a command to the history, dropping the oldest once full.
+func (s *Shell) record(cmd string) {
+	if s.maxHistory == 0 {
+		return
+	}
+
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	if len(s.history) >= s.maxHistory {
+		s.history = append(s.history[:0], s.history[len(s.history)-s.maxHistory+1:]...)
+	}
+	s.history = append(s.history, cmd)
+}
@@ -0,0 +1,75 @@
+package gopwsh
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// ErrIdleClosed is returned, wrapped, when executing a command on a Shell that
+// was closed by IdleTimeout, test for it with errors.Is.
+var ErrIdleClosed = errors.New("shell idle-closed")
+
+// IdleTimeout makes the Shell exit once no command has been executed for the
+// given duration, freeing the PowerShell process, eg: for shells that are
+// pooled by a long running server.
+//
+// The timer starts when the Shell is created & is reset every time a command
+// completes, a command that takes longer than the timeout is never interrupted.
+// Once closed, executing a command returns an error that wraps ErrIdleClosed.
+//
+// Defaults to 0, shells never exit on their own.
+func IdleTimeout(d time.Duration) func(*Shell) error {
+	return func(s *Shell) error {
+		if d < 0 {
+			return goerr.New(fmt.Sprintf("IdleTimeout can not be negative, got %s", d))
+		}
+		s.idleTimeout = d
+		return nil
+	}
+}
+
+// touch records that the Shell has just been used & arms the idle timer if it
+// isn't already. Must be called with the lock held.
+func (s *Shell) touch() {
+	if s.idleTimeout <= 0 || s.backend == nil {
+		return
+	}
+
+	s.lastUsed = time.Now()
+	if s.idleTimer == nil {
+		s.idleGen++
+		gen := s.idleGen
+		s.idleTimer = time.AfterFunc(s.idleTimeout, func() { s.idleExit(gen) })
+	}
+}
+
+// idleExit is called by the idle timer, rather than resetting the timer for
+// every command it is re-armed here for whatever is left of the timeout.
+func (s *Shell) idleExit(gen int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The timer was stopped, or replaced, while we waited for the lock
+	if s.idleTimer == nil || s.idleGen != gen {
+		return
+	}
+
+	if idle := time.Since(s.lastUsed); idle < s.idleTimeout {
+		s.idleTimer.Reset(s.idleTimeout - idle)
+		return
+	}
+
+	s.exit(s.exitTimeout)
+	s.idleClosed = true
+}
+
+// stopIdleTimer must be called with the lock held.
+func (s *Shell) stopIdleTimer() {
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+}
@@ -0,0 +1,41 @@
+package gopwsh
+
+import (
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// ExecuteWithInput executes a command that reads from the pipeline, eg: $input,
+// a filter or a native command that reads it's STDIN, feeding it the given
+// input one line at a time.
+//
+// PowerShell's own STDIN is used to send commands so the input can not simply
+// be written to it, instead the input is embedded into the command as a string
+// literal which is split into lines & piped into cmd, eg:
+//
+//	("foo`nbar") -split '\r?\n' | Sort-Object
+//
+// So cmd must be something that can follow a "|", the boundary protocol is
+// unaffected as the whole thing is still sent as a single command. A single
+// trailing line ending is ignored.
+func (s *Shell) ExecuteWithInput(stdin string, cmd string) (string, string, error) {
+	pipeline := "@() | " + cmd
+	if stdin != "" {
+		stdin = strings.TrimSuffix(strings.TrimSuffix(stdin, "\n"), "\r")
+		pipeline = "(" + QuoteArgDouble(stdin) + ") -split '\\r?\\n' | " + cmd
+	}
+
+	stdout, stderr, err := s.Execute(pipeline)
+	if err != nil {
+		return stdout, stderr, goerr.Wrap(err, "failed to execute with input", cmd)
+	}
+	return stdout, stderr, nil
+}
+
+// MustExecuteWithInput is the same as ExecuteWithInput but panics on error instead of returning an error.
+func (s *Shell) MustExecuteWithInput(stdin string, cmd string) (string, string) {
+	stdout, stderr, err := s.ExecuteWithInput(stdin, cmd)
+	goerr.Check(err)
+	return stdout, stderr
+}
@@ -0,0 +1,195 @@
+package gopwsh
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// Invoke executes a cmdlet, function or script with named parameters, without
+// having to build & quote the command string yourself.
+//
+// The parameters are converted with ToPSLiteral into a hashtable which is then
+// splatted, eg: & { $gopwshSplat = @{'Path' = 'C:\foo'; 'Recurse' = $true}; & 'Get-ChildItem' @gopwshSplat }
+//
+// Switch parameters can be given a bool, nil is passed as $null & slices as
+// arrays. The cmdlet name is quoted too, so it must be the name of a single
+// command, it can't contain any additional arguments.
+func (s *Shell) Invoke(cmdlet string, params map[string]interface{}) (string, string, error) {
+	cmd := "& " + QuoteArg(cmdlet)
+
+	if len(params) > 0 {
+		splat, err := ToPSLiteral(params)
+		if err != nil {
+			return "", "", goerr.Wrap(err, "failed to convert the parameters of", cmdlet)
+		}
+		// In a child scope so that $gopwshSplat is gone once it returns
+		cmd = "& { $gopwshSplat = " + splat + "; " + cmd + " @gopwshSplat }"
+	}
+
+	stdout, stderr, err := s.Execute(cmd)
+	if err != nil {
+		return stdout, stderr, goerr.Wrap(err, "failed to invoke", cmdlet)
+	}
+	return stdout, stderr, nil
+}
+
+// MustInvoke is the same as Invoke but panics on error instead of returning an error.
+func (s *Shell) MustInvoke(cmdlet string, params map[string]interface{}) (string, string) {
+	stdout, stderr, err := s.Invoke(cmdlet, params)
+	goerr.Check(err)
+	return stdout, stderr
+}
+
+// ExecuteScriptBlock executes a script block, passing it the given arguments
+// positionally, eg: & { param($Name, $Count) ... } 'foo' 3
+//
+// Each argument is converted with ToPSLiteral, so the script text can stay
+// static while the data is kept separate from it.
+//
+// e.g:
+//
+//	shell.ExecuteScriptBlock(`param($Path, $Recurse) Get-ChildItem $Path -Recurse:$Recurse`, "C:\\foo", true)
+func (s *Shell) ExecuteScriptBlock(scriptBlock string, args ...interface{}) (string, string, error) {
+	// On their own lines so that a trailing comment can't swallow the "}"
+	cmd := "& {" + s.lineEnding + scriptBlock + s.lineEnding + "}"
+	for i, arg := range args {
+		literal, err := ToPSLiteral(arg)
+		if err != nil {
+			return "", "", goerr.Wrap(err, fmt.Sprintf("failed to convert argument %d", i))
+		}
+		cmd = cmd + " " + literal
+	}
+
+	stdout, stderr, err := s.Execute(cmd)
+	if err != nil {
+		return stdout, stderr, goerr.Wrap(err, "failed to execute script block")
+	}
+	return stdout, stderr, nil
+}
+
+// MustExecuteScriptBlock is the same as ExecuteScriptBlock but panics on error instead of returning an error.
+func (s *Shell) MustExecuteScriptBlock(scriptBlock string, args ...interface{}) (string, string) {
+	stdout, stderr, err := s.ExecuteScriptBlock(scriptBlock, args...)
+	goerr.Check(err)
+	return stdout, stderr
+}
+
+// ExecuteTemplate executes a command in which each "{}" placeholder is
+// replaced with the next argument, converted with ToPSLiteral. Much like the
+// parameters of a SQL query, the arguments can never be interpreted as part
+// of the command, unlike those formatted into it with fmt.Sprintf.
+//
+// An error is returned if the number of placeholders & arguments differ.
+// Keep in mind "{}" is also an empty script block in PowerShell, use
+// {$null} or similar if you need one in the template.
+//
+// e.g:
+//
+//	shell.ExecuteTemplate("Get-ChildItem {} -Recurse:{}", userPath, true)
+func (s *Shell) ExecuteTemplate(tmpl string, args ...interface{}) (string, string, error) {
+	parts := strings.Split(tmpl, "{}")
+	if len(parts)-1 != len(args) {
+		return "", "", goerr.Wrap(fmt.Sprintf("the template has %d placeholders but %d arguments were given", len(parts)-1, len(args)), tmpl)
+	}
+
+	var cmd strings.Builder
+	cmd.WriteString(parts[0])
+	for i, arg := range args {
+		literal, err := ToPSLiteral(arg)
+		if err != nil {
+			return "", "", goerr.Wrap(err, fmt.Sprintf("failed to convert argument %d", i))
+		}
+		cmd.WriteString(literal)
+		cmd.WriteString(parts[i+1])
+	}
+
+	stdout, stderr, err := s.Execute(cmd.String())
+	if err != nil {
+		return stdout, stderr, goerr.Wrap(err, "failed to execute template", tmpl)
+	}
+	return stdout, stderr, nil
+}
+
+// MustExecuteTemplate is the same as ExecuteTemplate but panics on error instead of returning an error.
+func (s *Shell) MustExecuteTemplate(tmpl string, args ...interface{}) (string, string) {
+	stdout, stderr, err := s.ExecuteTemplate(tmpl, args...)
+	goerr.Check(err)
+	return stdout, stderr
+}
+
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ExecuteWith defines a variable for each of vars, converted with
+// ToPSLiteral, executes the command & then removes the variables again, even
+// if the command fails. The command can then reference the values, eg: $Path,
+// without them being formatted into it's text. Script blocks run elsewhere,
+// eg: by Start-Job or Invoke-Command, can reference them as $using:Path.
+//
+// Variable names must be made up of letters, numbers & underscores only. A
+// variable of the same name that already existed is restored afterwards.
+//
+// e.g:
+//
+//	shell.ExecuteWith(map[string]interface{}{"Path": userPath}, "Get-Item $Path")
+func (s *Shell) ExecuteWith(vars map[string]interface{}, cmd string) (string, string, error) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		if !variableName.MatchString(name) {
+			return "", "", goerr.Wrap("invalid variable name", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = QuoteArg(name)
+	}
+
+	if len(names) == 0 {
+		stdout, stderr, err := s.execute(context.Background(), cmd)
+		if err != nil {
+			return stdout, stderr, goerr.Wrap(err, "failed to execute", cmd)
+		}
+		return stdout, stderr, nil
+	}
+
+	// The values of any existing variables are saved, a copy of them as
+	// Get-Variable returns the variables themselves.
+	var full strings.Builder
+	full.WriteString("$gopwshSaved = @(Get-Variable -Name " + strings.Join(quoted, ", ") + " -ErrorAction SilentlyContinue | " +
+		"ForEach-Object { [pscustomobject]@{ Name = $_.Name; Value = $_.Value } }); ")
+	for _, name := range names {
+		literal, err := ToPSLiteral(vars[name])
+		if err != nil {
+			return "", "", goerr.Wrap(err, "failed to convert variable", name)
+		}
+		full.WriteString("$" + name + " = " + literal + "; ")
+	}
+
+	// All in one command, so that nothing else can run before the variables
+	// are restored, the same way as ExecuteTimed keeps $? that of cmd.
+	full.WriteString("$gopwshOk = $false; try {" + s.lineEnding + cmd + s.lineEnding + "$gopwshOk = $? } finally { " +
+		"Remove-Variable -Name " + strings.Join(quoted, ", ") + " -ErrorAction SilentlyContinue; " +
+		"$gopwshSaved | ForEach-Object { Set-Variable -Name $_.Name -Value $_.Value }; " +
+		"Remove-Variable gopwshSaved -ErrorAction SilentlyContinue }; " +
+		"if (-not $gopwshOk) { Write-Error 'failed' -ErrorAction Ignore }")
+
+	stdout, stderr, err := s.execute(context.Background(), full.String())
+	if err != nil {
+		return stdout, stderr, goerr.Wrap(err, "failed to execute", cmd)
+	}
+	return stdout, stderr, nil
+}
+
+// MustExecuteWith is the same as ExecuteWith but panics on error instead of returning an error.
+func (s *Shell) MustExecuteWith(vars map[string]interface{}, cmd string) (string, string) {
+	stdout, stderr, err := s.ExecuteWith(vars, cmd)
+	goerr.Check(err)
+	return stdout, stderr
+}
@@ -0,0 +1,85 @@
+package gopwsh
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/brad-jones/gopwsh/backend"
+)
+
+func TestExecuteWith(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]interface{}
+		want []string
+	}{
+		{"no variables", nil, []string{"Get-Item $Path"}},
+		{
+			// A single command, so that nothing can run before the variables are restored
+			"variables",
+			map[string]interface{}{"Path": `C:\foo`, "Count": 3},
+			[]string{"$gopwshSaved = @(Get-Variable -Name 'Count', 'Path' -ErrorAction SilentlyContinue | " +
+				"ForEach-Object { [pscustomobject]@{ Name = $_.Name; Value = $_.Value } }); " +
+				`$Count = 3; $Path = 'C:\foo'; $gopwshOk = $false; try {` + "\n" +
+				"Get-Item $Path\n" +
+				"$gopwshOk = $? } finally { Remove-Variable -Name 'Count', 'Path' -ErrorAction SilentlyContinue; " +
+				"$gopwshSaved | ForEach-Object { Set-Variable -Name $_.Name -Value $_.Value }; " +
+				"Remove-Variable gopwshSaved -ErrorAction SilentlyContinue }; " +
+				"if (-not $gopwshOk) { Write-Error 'failed' -ErrorAction Ignore }"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := backend.NewMock()
+			s := newMockShell(t, b)
+			started := len(b.Commands())
+
+			if _, _, err := s.ExecuteWith(tt.vars, "Get-Item $Path"); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.Commands()[started:]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got commands %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteWithInvalidName(t *testing.T) {
+	b := backend.NewMock()
+	s := newMockShell(t, b)
+	started := len(b.Commands())
+
+	if _, _, err := s.ExecuteWith(map[string]interface{}{"a; rm -r": 1}, "Get-Item $Path"); err == nil {
+		t.Error("expected an error for an invalid variable name")
+	}
+	if got := b.Commands()[started:]; len(got) != 0 {
+		t.Errorf("got commands %q, want none", got)
+	}
+}
+
+func TestInvoke(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		want   string
+	}{
+		{"no parameters", nil, "& 'Get-ChildItem'"},
+		{"parameters", map[string]interface{}{"Path": `C:\foo`, "Recurse": true}, `& { $gopwshSplat = @{'Path' = 'C:\foo'; 'Recurse' = $true}; & 'Get-ChildItem' @gopwshSplat }`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := backend.NewMock()
+			s := newMockShell(t, b)
+			started := len(b.Commands())
+
+			if _, _, err := s.Invoke("Get-ChildItem", tt.params); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.Commands()[started:]; !reflect.DeepEqual(got, []string{tt.want}) {
+				t.Errorf("got commands %q, want %q", got, []string{tt.want})
+			}
+		})
+	}
+}
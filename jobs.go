@@ -0,0 +1,104 @@
+package gopwsh
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// StartJob starts a command as a PowerShell background job, with Start-Job,
+// & returns the job's id straight away. Several long running commands can
+// then be run in parallel on a single Shell, see WaitJob & GetJobState.
+//
+// Keep in mind jobs run in their own process, so variables, functions &
+// modules of the Shell's session are not available to the command.
+//
+// e.g:
+//
+//	a, _ := shell.StartJob("Invoke-WebRequest https://example.com/a.zip -OutFile a.zip")
+//	b, _ := shell.StartJob("Invoke-WebRequest https://example.com/b.zip -OutFile b.zip")
+//	shell.WaitJob(a)
+//	shell.WaitJob(b)
+func (s *Shell) StartJob(cmd string) (string, error) {
+	stdout, _, err := s.execute(context.Background(),
+		"(Start-Job -ScriptBlock ([ScriptBlock]::Create("+QuoteArg(cmd)+"))).Id",
+	)
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to start job", cmd)
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// MustStartJob is the same as StartJob but panics on error instead of returning an error.
+func (s *Shell) MustStartJob(cmd string) string {
+	jobID, err := s.StartJob(cmd)
+	goerr.Check(err)
+	return jobID
+}
+
+// WaitJob waits for a job to complete, with Wait-Job, & returns it's output.
+// The job is then removed so WaitJob can only be called once per job.
+//
+// An error is returned if the job failed, eg: it threw an exception, the
+// reason is also written to STDERR.
+func (s *Shell) WaitJob(jobID string) (string, string, error) {
+	id, err := parseJobID(jobID)
+	if err != nil {
+		return "", "", err
+	}
+
+	stdout, stderr, err := s.execute(context.Background(),
+		"$gopwshJob = Get-Job -Id "+id+" -ErrorAction Stop; "+
+			"$null = Wait-Job -Job $gopwshJob; "+
+			"Receive-Job -Job $gopwshJob -Wait -AutoRemoveJob; "+
+			"if ($gopwshJob.State -eq 'Failed') { throw 'Job "+id+" failed' }",
+	)
+	if err != nil {
+		return stdout, stderr, goerr.Wrap(err, "failed to wait for job", jobID)
+	}
+	return stdout, stderr, nil
+}
+
+// MustWaitJob is the same as WaitJob but panics on error instead of returning an error.
+func (s *Shell) MustWaitJob(jobID string) (string, string) {
+	stdout, stderr, err := s.WaitJob(jobID)
+	goerr.Check(err)
+	return stdout, stderr
+}
+
+// GetJobState returns the state of a job without waiting for it, eg:
+// "Running", "Completed" or "Failed".
+//
+// see: https://docs.microsoft.com/en-us/dotnet/api/system.management.automation.jobstate
+func (s *Shell) GetJobState(jobID string) (string, error) {
+	id, err := parseJobID(jobID)
+	if err != nil {
+		return "", err
+	}
+
+	stdout, _, err := s.execute(context.Background(),
+		"(Get-Job -Id "+id+" -ErrorAction Stop).State.ToString()",
+	)
+	if err != nil {
+		return "", goerr.Wrap(err, "failed to get the state of job", jobID)
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// MustGetJobState is the same as GetJobState but panics on error instead of returning an error.
+func (s *Shell) MustGetJobState(jobID string) string {
+	state, err := s.GetJobState(jobID)
+	goerr.Check(err)
+	return state
+}
+
+// parseJobID makes sure a job id is a number before it is put into a command.
+func parseJobID(jobID string) (string, error) {
+	id, err := strconv.Atoi(strings.TrimSpace(jobID))
+	if err != nil {
+		return "", goerr.Wrap(err, "invalid job id", jobID)
+	}
+	return strconv.Itoa(id), nil
+}
@@ -0,0 +1,161 @@
+package gopwsh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// JSONDepth sets the -Depth given to ConvertTo-Json by ExecuteJSON.
+//
+// Defaults to 10. PowerShell will not accept a depth greater than 100.
+func JSONDepth(depth int) func(*Shell) error {
+	return func(s *Shell) error {
+		if depth < 1 || depth > 100 {
+			return goerr.New(fmt.Sprintf("JSONDepth must be between 1 & 100, got %d", depth))
+		}
+		s.jsonDepth = depth
+		return nil
+	}
+}
+
+// ExecuteJSON executes a command, converts it's output with ConvertTo-Json &
+// then unmarshals the JSON into v.
+//
+// ConvertTo-Json on it's own would not wrap a single object in an array so we
+// always collect the output into an array on the PowerShell side. If v is a
+// slice or an array it will always be given all of the objects, otherwise v is
+// given the single object that was output. If the command outputs nothing,
+// or only $null, then v is left untouched & if v is not a slice but multiple
+// objects were output then an error is returned.
+//
+// e.g:
+//
+//	var procs []struct{ Name string; Id int }
+//	err := shell.ExecuteJSON("Get-Process", &procs)
+func (s *Shell) ExecuteJSON(cmd string, v interface{}) error {
+	_, err := s.executeJSON(cmd, v)
+	return err
+}
+
+// executeJSON does the actual work of ExecuteJSON, output is false when the
+// command output nothing, or only $null, & so v was left untouched.
+func (s *Shell) executeJSON(cmd string, v interface{}) (output bool, err error) {
+	// On their own lines so that a trailing comment can't swallow the "}"
+	wrapped := fmt.Sprintf("ConvertTo-Json -InputObject @(. {%[3]s%[1]s%[3]s}) -Depth %[2]d -Compress", cmd, s.jsonDepth, s.lineEnding)
+
+	stdout, stderr, err := s.execute(context.Background(), wrapped)
+	if err != nil {
+		return false, goerr.Wrap(err, "failed to execute", cmd)
+	}
+
+	var objects []json.RawMessage
+	if err := json.Unmarshal([]byte(strings.TrimSpace(stdout)), &objects); err != nil {
+		return false, goerr.Wrap(err, "failed to decode the JSON output of", cmd, stderr)
+	}
+
+	var data []byte
+	switch {
+	case len(objects) == 0, allNull(objects):
+		return false, nil
+	case isSliceOrArray(v):
+		data = []byte(strings.TrimSpace(stdout))
+	case len(objects) == 1:
+		data = objects[0]
+	default:
+		return true, goerr.Wrap(fmt.Sprintf("expected a single object but the command output %d", len(objects)), cmd)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return true, goerr.Wrap(err, "failed to unmarshal the JSON output of", cmd)
+	}
+	return true, nil
+}
+
+// MustExecuteJSON is the same as ExecuteJSON but panics on error instead of returning an error.
+func (s *Shell) MustExecuteJSON(cmd string, v interface{}) {
+	goerr.Check(s.ExecuteJSON(cmd, v))
+}
+
+// ExecuteInto is like shell.ExecuteJSON(cmd, dest), for consumers that
+// would rather pass the Shell around than call methods on it.
+//
+// dest is decoded with encoding/json so json tags are respected. When dest is
+// a slice it is given every object even if the command output just one, when
+// the command outputs nothing, or only $null, dest is set to it's zero value.
+func ExecuteInto(s *Shell, cmd string, dest interface{}) error {
+	output, err := s.executeJSON(cmd, dest)
+	if err != nil {
+		return err
+	}
+	if !output {
+		if v := reflect.ValueOf(dest); v.Kind() == reflect.Ptr && !v.IsNil() {
+			v.Elem().Set(reflect.Zero(v.Elem().Type()))
+		}
+	}
+	return nil
+}
+
+// MustExecuteInto is the same as ExecuteInto but panics on error instead of returning an error.
+func MustExecuteInto(s *Shell, cmd string, dest interface{}) {
+	goerr.Check(ExecuteInto(s, cmd, dest))
+}
+
+// allNull is true when the command only output $null, eg: a function that
+// ends with "return $null".
+func allNull(objects []json.RawMessage) bool {
+	for _, o := range objects {
+		if string(o) != "null" {
+			return false
+		}
+	}
+	return true
+}
+
+func isSliceOrArray(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return false
+	}
+	switch t.Elem().Kind() {
+	case reflect.Slice, reflect.Array:
+		return true
+	}
+	return false
+}
+
+// SetObject marshals v to JSON & converts it back into an object on the
+// PowerShell side, with ConvertFrom-Json, storing it in the global variable
+// called name. Later commands can then use it, eg: $name.SomeProperty.
+// This is the reverse of ExecuteJSON.
+//
+// Structs become PSCustomObjects, keep in mind that like any PowerShell
+// expression a slice with a single element is unwrapped to just the element.
+//
+// e.g:
+//
+//	shell.SetObject("config", struct{ Name string }{"foo"})
+//	stdout, _, err := shell.Execute("$config.Name")
+func (s *Shell) SetObject(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return goerr.Wrap(err, "failed to marshal the value of", name)
+	}
+
+	cmd := "Set-Variable -Scope Global -Name " + QuoteArg(name) +
+		" -Value (ConvertFrom-Json -InputObject " + QuoteArg(string(data)) + ")"
+
+	if _, _, err := s.execute(context.Background(), cmd); err != nil {
+		return goerr.Wrap(err, "failed to set object", name)
+	}
+	return nil
+}
+
+// MustSetObject is the same as SetObject but panics on error instead of returning an error.
+func (s *Shell) MustSetObject(name string, v interface{}) {
+	goerr.Check(s.SetObject(name, v))
+}
@@ -0,0 +1,46 @@
+package gopwsh
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/brad-jones/gopwsh/backend"
+)
+
+type jsonProcess struct {
+	Name string `json:"ProcessName"`
+	ID   int    `json:"Id"`
+}
+
+func TestExecuteInto(t *testing.T) {
+	const wrapped = "ConvertTo-Json -InputObject @(. {\nGet-Process\n}) -Depth 10 -Compress"
+
+	tests := []struct {
+		name   string
+		stdout string
+		dest   func() interface{}
+		want   interface{}
+	}{
+		{"single object", `[{"ProcessName":"pwsh","Id":1}]` + "\n", func() interface{} { return &jsonProcess{} }, &jsonProcess{"pwsh", 1}},
+		{"single object into a slice", `[{"ProcessName":"pwsh","Id":1}]` + "\n", func() interface{} { return &[]jsonProcess{} }, &[]jsonProcess{{"pwsh", 1}}},
+		{"many objects", `[{"ProcessName":"a","Id":1},{"ProcessName":"b","Id":2}]` + "\n", func() interface{} { return &[]jsonProcess{} }, &[]jsonProcess{{"a", 1}, {"b", 2}}},
+		{"empty", "[]\n", func() interface{} { return &jsonProcess{"stale", 9} }, &jsonProcess{}},
+		{"empty into a slice", "[]\n", func() interface{} { return &[]jsonProcess{{"stale", 9}} }, new([]jsonProcess)},
+		{"null", "[null]\n", func() interface{} { return &jsonProcess{"stale", 9} }, &jsonProcess{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := backend.NewMock().Expect(wrapped, tt.stdout, "")
+			s := newMockShell(t, b)
+
+			dest := tt.dest()
+			if err := ExecuteInto(s, "Get-Process", dest); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dest, tt.want) {
+				t.Errorf("got %+v, want %+v", dest, tt.want)
+			}
+		})
+	}
+}
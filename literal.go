@@ -0,0 +1,133 @@
+package gopwsh
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// ToPSLiteral converts a Go value into the equivalent PowerShell literal,
+// so that it can be safely used when building a command.
+//
+// The following conversions are supported:
+//
+//	nil, nil pointers, maps & slices -> $null
+//	bool                             -> $true / $false
+//	ints, uints & floats             -> 123, -1.5, ([double]::NaN)
+//	string                           -> 'foo' (see QuoteArg)
+//	slices & arrays                  -> @(1, 'foo')
+//	maps                             -> @{'foo' = 1; 'bar' = @(1, 2)}
+//
+// Pointers & interfaces are followed, nested values are converted recursively.
+// Map keys must be strings, bools or numbers. Any other type, such as a
+// struct, results in an error.
+func ToPSLiteral(v interface{}) (string, error) {
+	return toPSLiteral(reflect.ValueOf(v))
+}
+
+// MustToPSLiteral is the same as ToPSLiteral but panics on error instead of returning an error.
+func MustToPSLiteral(v interface{}) string {
+	literal, err := ToPSLiteral(v)
+	goerr.Check(err)
+	return literal
+}
+
+func toPSLiteral(v reflect.Value) (string, error) {
+	if !v.IsValid() {
+		return "$null", nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return "$null", nil
+		}
+		return toPSLiteral(v.Elem())
+
+	case reflect.Bool:
+		if v.Bool() {
+			return "$true", nil
+		}
+		return "$false", nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		// In parentheses, otherwise a command argument is taken as a string
+		switch {
+		case math.IsNaN(f):
+			return "([double]::NaN)", nil
+		case math.IsInf(f, 1):
+			return "([double]::PositiveInfinity)", nil
+		case math.IsInf(f, -1):
+			return "([double]::NegativeInfinity)", nil
+		}
+		return strconv.FormatFloat(f, 'g', -1, v.Type().Bits()), nil
+
+	case reflect.String:
+		return QuoteArg(v.String()), nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return "$null", nil
+		}
+		items := make([]string, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item, err := toPSLiteral(v.Index(i))
+			if err != nil {
+				return "", goerr.Wrap(err, fmt.Sprintf("failed to convert item %d", i))
+			}
+			items[i] = item
+		}
+		return "@(" + strings.Join(items, ", ") + ")", nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return "$null", nil
+		}
+		entries := make([]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := toPSMapKey(iter.Key())
+			if err != nil {
+				return "", err
+			}
+			value, err := toPSLiteral(iter.Value())
+			if err != nil {
+				return "", goerr.Wrap(err, "failed to convert the value of key", key)
+			}
+			entries = append(entries, key+" = "+value)
+		}
+		// Go randomises map iteration, sorting makes the output deterministic
+		sort.Strings(entries)
+		return "@{" + strings.Join(entries, "; ") + "}", nil
+	}
+
+	return "", goerr.Wrap("unsupported type, can not convert to a PowerShell literal", v.Type().String())
+}
+
+func toPSMapKey(k reflect.Value) (string, error) {
+	for k.Kind() == reflect.Interface && !k.IsNil() {
+		k = k.Elem()
+	}
+
+	switch k.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return toPSLiteral(k)
+	}
+
+	return "", goerr.Wrap("unsupported map key type, keys must be strings, bools or numbers", k.Type().String())
+}
@@ -0,0 +1,72 @@
+package gopwsh
+
+import (
+	"math"
+	"testing"
+)
+
+func TestToPSLiteral(t *testing.T) {
+	var nilPtr *int
+	one := 1
+
+	tests := []struct {
+		name string
+		in   interface{}
+		want string
+	}{
+		{"nil", nil, "$null"},
+		{"nil pointer", nilPtr, "$null"},
+		{"nil slice", []int(nil), "$null"},
+		{"nil map", map[string]int(nil), "$null"},
+		{"true", true, "$true"},
+		{"false", false, "$false"},
+		{"int", -42, "-42"},
+		{"uint", uint8(255), "255"},
+		{"float", -1.5, "-1.5"},
+		{"float32", float32(0.1), "0.1"},
+		{"NaN", math.NaN(), "([double]::NaN)"},
+		{"positive infinity", math.Inf(1), "([double]::PositiveInfinity)"},
+		{"negative infinity", math.Inf(-1), "([double]::NegativeInfinity)"},
+		{"string", "it's", "'it''s'"},
+		{"pointer", &one, "1"},
+		{"empty slice", []string{}, "@()"},
+		{"slice", []interface{}{1, "foo", nil}, "@(1, 'foo', $null)"},
+		{"array", [2]bool{true, false}, "@($true, $false)"},
+		{"map", map[string]interface{}{"foo": 1, "bar": []int{1, 2}}, "@{'bar' = @(1, 2); 'foo' = 1}"},
+		{"map with number keys", map[int]string{2: "b", 1: "a"}, "@{1 = 'a'; 2 = 'b'}"},
+		{"nested map", map[string]map[string]bool{"a": {"b": true}}, "@{'a' = @{'b' = $true}}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToPSLiteral(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ToPSLiteral(%#v) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToPSLiteralUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+	}{
+		{"struct", struct{}{}},
+		{"func", func() {}},
+		{"struct in slice", []interface{}{1, struct{}{}}},
+		{"struct map key", map[struct{}]int{{}: 1}},
+		{"struct map value", map[string]interface{}{"a": struct{}{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := ToPSLiteral(tt.in); err == nil {
+				t.Errorf("ToPSLiteral(%#v) = %q, want an error", tt.in, got)
+			}
+		})
+	}
+}
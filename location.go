@@ -0,0 +1,70 @@
+package gopwsh
+
+import (
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// SetWorkingDir changes the current location of the PowerShell process, with
+// Set-Location, an error is returned if it fails, eg: the path doesn't exist.
+//
+// Unlike the WorkingDir option this can be used at any time, the path is
+// resolved by the PowerShell process so may be relative to it's current
+// location. A process restarted by AutoRestart starts in the WorkingDir again.
+func (s *Shell) SetWorkingDir(path string) error {
+	stdout, stderr, err := s.Execute("Set-Location -LiteralPath " + QuoteArg(path) + "; $?")
+	if err != nil {
+		return goerr.Wrap(err, "failed to set the working dir", path)
+	}
+	if strings.TrimSpace(stdout) != "True" {
+		return goerr.Wrap(strings.TrimSpace(stderr), "failed to set the working dir", path)
+	}
+	return nil
+}
+
+// MustSetWorkingDir is the same as SetWorkingDir but panics on error instead of returning an error.
+func (s *Shell) MustSetWorkingDir(path string) {
+	goerr.Check(s.SetWorkingDir(path))
+}
+
+// PushLocation saves the current location of the PowerShell process, with
+// Push-Location, & then changes to path, an error is returned if it fails,
+// eg: the path doesn't exist, in which case nothing is saved.
+//
+// Use PopLocation to return to the saved location, eg: after running a few
+// commands in path. Locations are saved on PowerShell's default stack so they
+// are shared with any command that uses Push-Location without a -StackName.
+func (s *Shell) PushLocation(path string) error {
+	stdout, stderr, err := s.Execute("Push-Location -LiteralPath " + QuoteArg(path) + "; $?")
+	if err != nil {
+		return goerr.Wrap(err, "failed to push location", path)
+	}
+	if strings.TrimSpace(stdout) != "True" {
+		return goerr.Wrap(strings.TrimSpace(stderr), "failed to push location", path)
+	}
+	return nil
+}
+
+// MustPushLocation is the same as PushLocation but panics on error instead of returning an error.
+func (s *Shell) MustPushLocation(path string) {
+	goerr.Check(s.PushLocation(path))
+}
+
+// PopLocation changes the current location of the PowerShell process back to
+// the one most recently saved by PushLocation, with Pop-Location.
+func (s *Shell) PopLocation() error {
+	stdout, stderr, err := s.Execute("Pop-Location; $?")
+	if err != nil {
+		return goerr.Wrap(err, "failed to pop location")
+	}
+	if strings.TrimSpace(stdout) != "True" {
+		return goerr.Wrap(strings.TrimSpace(stderr), "failed to pop location")
+	}
+	return nil
+}
+
+// MustPopLocation is the same as PopLocation but panics on error instead of returning an error.
+func (s *Shell) MustPopLocation() {
+	goerr.Check(s.PopLocation())
+}
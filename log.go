@@ -0,0 +1,156 @@
+package gopwsh
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// LogDirection describes which way a LogEvent's payload was travelling.
+type LogDirection string
+
+const (
+	// LogSent is used for everything written to PowerShell's STDIN.
+	LogSent LogDirection = "Sent"
+
+	// LogStdout is used for each line read from PowerShell's STDOUT.
+	LogStdout LogDirection = "Stdout"
+
+	// LogStderr is used for each line read from PowerShell's STDERR.
+	LogStderr LogDirection = "Stderr"
+)
+
+// LogEvent is given to the function set with the Logger option.
+type LogEvent struct {
+	// Command is the command, as given to Execute & friends, that caused the
+	// event. It is empty for anything that gopwsh sends on it's own behalf,
+	// eg: a Ping.
+	Command string
+
+	Direction LogDirection
+
+	// Payload is exactly what was written, including the markers that are
+	// added to every command, or a single line of output, including the
+	// line ending.
+	Payload string
+}
+
+// Logger sets a function that is called with everything written to & read
+// from the PowerShell process, useful for debugging.
+//
+// Calls are serialized, the function does not need to be safe for concurrent
+// use, but it is called while reading the output of a command so it should
+// return quickly.
+//
+// Quoted strings in the command, such as those created by QuoteArg, are
+// replaced with '***' in both Command & the Payload of sent events, as they may
+// well contain secrets. Use VerboseLogging to log them as is.
+func Logger(fn func(event LogEvent)) func(*Shell) error {
+	return func(s *Shell) error {
+		s.logger = fn
+		return nil
+	}
+}
+
+// VerboseLogging stops the Logger from redacting quoted strings.
+func VerboseLogging(v bool) func(*Shell) error {
+	return func(s *Shell) error {
+		s.logVerbose = v
+		return nil
+	}
+}
+
+var quotedString = regexp.MustCompile("'(?:[^']|'')*'|\"(?:[^\"`]|`.)*\"")
+
+func (s *Shell) log(direction LogDirection, cmd, payload string) {
+	if s.logger == nil {
+		return
+	}
+
+	if !s.logVerbose {
+		redacted := quotedString.ReplaceAllString(cmd, "'***'")
+		if direction == LogSent && cmd != "" {
+			payload = strings.Replace(payload, cmd, redacted, 1)
+		}
+		cmd = redacted
+	}
+
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	s.logger(LogEvent{Command: cmd, Direction: direction, Payload: payload})
+}
+
+// logLines wraps a line callback so that each line is also logged.
+func (s *Shell) logLines(direction LogDirection, cmd string, fn func(string)) func(string) {
+	if s.logger == nil {
+		return fn
+	}
+	return func(line string) {
+		s.log(direction, cmd, line)
+		fn(line)
+	}
+}
+
+// secretKey is the context key for text that must never be logged, even with
+// VerboseLogging, see SetCredential.
+type secretKey struct{}
+
+func withSecret(ctx context.Context, secret string) context.Context {
+	return context.WithValue(ctx, secretKey{}, secret)
+}
+
+// redactLines wraps a line callback so that the secret, if any, is redacted
+// from each line before anything else, eg: a Logger or Tee, sees it.
+func redactLines(ctx context.Context, fn func(string)) func(string) {
+	if secret, ok := ctx.Value(secretKey{}).(string); !ok || secret == "" {
+		return fn
+	}
+	return func(line string) {
+		fn(redactSecret(ctx, line))
+	}
+}
+
+func redactSecret(ctx context.Context, v string) string {
+	if secret, ok := ctx.Value(secretKey{}).(string); ok && secret != "" {
+		return strings.ReplaceAll(v, secret, "'***'")
+	}
+	return v
+}
+
+// redactedError hides the secret, if any, from the message of an error while
+// errors.As can still find the error types it wraps, eg: *RuntimeError.
+type redactedError struct {
+	err    error
+	secret string
+}
+
+func (e *redactedError) Error() string {
+	return strings.ReplaceAll(e.err.Error(), e.secret, "'***'")
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactError wraps err in a redactedError when ctx carries a secret. The
+// text fields of a *ParserError or *RuntimeError are redacted too.
+func redactError(ctx context.Context, err error) error {
+	secret, ok := ctx.Value(secretKey{}).(string)
+	if err == nil || !ok || secret == "" {
+		return err
+	}
+
+	var parserErr *ParserError
+	if errors.As(err, &parserErr) {
+		parserErr.Output = redactSecret(ctx, parserErr.Output)
+	}
+	var runtimeErr *RuntimeError
+	if errors.As(err, &runtimeErr) {
+		runtimeErr.Stderr = redactSecret(ctx, runtimeErr.Stderr)
+		runtimeErr.Message = redactSecret(ctx, runtimeErr.Message)
+		runtimeErr.ScriptStackTrace = redactSecret(ctx, runtimeErr.ScriptStackTrace)
+	}
+
+	return &redactedError{err: err, secret: secret}
+}
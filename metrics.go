@@ -0,0 +1,63 @@
+package gopwsh
+
+import (
+	"time"
+)
+
+// CommandMetrics is given to the function set with the Metrics option once
+// each command has completed.
+type CommandMetrics struct {
+	// Command is the command as given to Execute & friends, it may have been
+	// wrapped by methods such as ExecuteJSON. The password given to
+	// SetCredential is redacted, from Err too.
+	Command string
+
+	// Start is when the command was sent, after waiting for any other
+	// command to complete.
+	Start time.Time
+
+	// Duration is how long it took from sending the command until all of it's
+	// output had been read.
+	Duration time.Duration
+
+	StdoutBytes int
+	StderrBytes int
+
+	// Err is the error the command returned, if any.
+	Err error
+}
+
+// Metrics sets a function that is called with the timing & size of every
+// command executed by the Shell, eg: to feed Prometheus or OpenTelemetry.
+//
+// It is called before the next command is sent so it should return quickly.
+// Commands sent with DryRun & anything that gopwsh sends on it's own behalf,
+// eg: a Ping, are not measured.
+func Metrics(fn func(m CommandMetrics)) func(*Shell) error {
+	return func(s *Shell) error {
+		s.metrics = fn
+		return nil
+	}
+}
+
+// measure wraps the callbacks of a command so that the bytes of output are
+// counted, finish must be called once the command has completed.
+func (s *Shell) measure(cmd string, onStdout, onStderr func(string)) (func(string), func(string), func(error)) {
+	m := CommandMetrics{Command: cmd, Start: time.Now()}
+
+	stdout := func(line string) {
+		m.StdoutBytes += len(line)
+		onStdout(line)
+	}
+	stderr := func(line string) {
+		m.StderrBytes += len(line)
+		onStderr(line)
+	}
+	finish := func(err error) {
+		m.Duration = time.Since(m.Start)
+		m.Err = err
+		s.metrics(m)
+	}
+
+	return stdout, stderr, finish
+}
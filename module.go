@@ -0,0 +1,57 @@
+package gopwsh
+
+import (
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// ImportModule imports a PowerShell module, with Import-Module, into the
+// global scope & returns an error if it could not be loaded, eg: because it
+// is not installed. Use it as a precondition before executing the module's
+// cmdlets rather than discovering mid-script that they do not exist.
+//
+// name may be the name of an installed module or the path to one.
+func (s *Shell) ImportModule(name string) error {
+	return s.importModule(name, "")
+}
+
+// MustImportModule is the same as ImportModule but panics on error instead of returning an error.
+func (s *Shell) MustImportModule(name string) {
+	goerr.Check(s.ImportModule(name))
+}
+
+// ImportModuleVersion is the same as ImportModule but also requires the
+// module's version to be at least minVersion, eg: "2.1.0".
+func (s *Shell) ImportModuleVersion(name, minVersion string) error {
+	return s.importModule(name, minVersion)
+}
+
+// MustImportModuleVersion is the same as ImportModuleVersion but panics on error instead of returning an error.
+func (s *Shell) MustImportModuleVersion(name, minVersion string) {
+	goerr.Check(s.ImportModuleVersion(name, minVersion))
+}
+
+func (s *Shell) importModule(name, minVersion string) error {
+	cmd := "Import-Module -Name " + QuoteArg(name) + " -Global -ErrorAction Stop"
+	if minVersion != "" {
+		cmd = cmd + " -MinimumVersion " + QuoteArg(minVersion)
+	}
+
+	// The message of the terminating error is output before the result so
+	// that the result is always the last line.
+	stdout, stderr, err := s.Execute("try { $null = " + cmd + "; 'True' } catch { $_.Exception.Message; 'False' }")
+	if err != nil {
+		return goerr.Wrap(err, "failed to import module", name)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if strings.TrimSpace(lines[len(lines)-1]) != "True" {
+		msg := strings.TrimSpace(strings.Join(lines[:len(lines)-1], "\n"))
+		if msg == "" {
+			msg = strings.TrimSpace(stderr)
+		}
+		return goerr.Wrap(msg, "failed to import module", name)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package gopwsh
+
+import (
+	"encoding/base64"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// OneShot runs a script in a brand new PowerShell process, without -NoExit,
+// & returns all of it's output once the process has exited. This is lighter
+// than New, Execute & then Exit for fire-and-forget scripts that don't need a
+// session to be reused.
+//
+// The same options as New are accepted, those that only make sense for a
+// long running shell, eg: AutoRestart or IdleTimeout, are simply ignored.
+// Startup commands, including the one for OutputEncoding, are run before
+// the script.
+//
+// The script is given to PowerShell with -EncodedCommand, so it can't be
+// mangled by any quoting rules, however the encoded script must fit on the
+// command line of the backend, roughly 12KB of script on Windows. An error is
+// returned if PowerShell exits with a non-zero code, along with the output.
+//
+// e.g:
+//
+//	stdout, stderr, err := gopwsh.OneShot("Get-Date", gopwsh.NoProfile())
+func OneShot(script string, decorators ...func(*Shell) error) (stdout, stderr string, err error) {
+	defer goerr.Handle(func(e error) { err = e })
+
+	s, err := configure(decorators...)
+	goerr.Check(err)
+
+	full := strings.Join(append(s.startupCmds(), script), s.lineEnding)
+	goerr.Check(s.startProcess("-EncodedCommand", encodeCommand(full)))
+
+	// Nothing is ever written to stdin, close it so that PowerShell does not wait on it
+	if c, ok := s.backend.Stdin().(io.Closer); ok {
+		c.Close()
+	}
+
+	var outSb, errSb strings.Builder
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); io.Copy(&outSb, s.backend.Stdout()) }()
+	go func() { defer wg.Done(); io.Copy(&errSb, s.backend.Stderr()) }()
+	wg.Wait()
+
+	stdout, stderr = outSb.String(), errSb.String()
+	if err := s.backend.Wait(); err != nil {
+		return stdout, stderr, goerr.Wrap(err, "PowerShell exited with an error", stderr)
+	}
+	return stdout, stderr, nil
+}
+
+// MustOneShot is the same as OneShot but panics on error instead of returning an error.
+func MustOneShot(script string, decorators ...func(*Shell) error) (string, string) {
+	stdout, stderr, err := OneShot(script, decorators...)
+	goerr.Check(err)
+	return stdout, stderr
+}
+
+// encodeCommand encodes a script for -EncodedCommand, which expects base64
+// encoded UTF-16LE.
+func encodeCommand(script string) string {
+	units := utf16.Encode([]rune(script))
+	b := make([]byte, len(units)*2)
+	for i, u := range units {
+		b[i*2] = byte(u)
+		b[i*2+1] = byte(u >> 8)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,67 @@
+package gopwsh
+
+import (
+	"sync"
+)
+
+// OnExit sets a function that is called as soon as the PowerShell process
+// exits for any reason other than the Shell closing it, eg: Exit or
+// IdleTimeout. The error is the one returned by the backend's Wait, if any.
+//
+// The process is watched in the background, so a process that dies between
+// commands is noticed straight away rather than by the next command. It is
+// then cleaned up, or restarted when AutoRestart is enabled. Processes killed
+// by Kill, a timeout or a cancelled context are also reported.
+//
+// fn is called from a background goroutine, without the Shell's lock held,
+// so it may use the Shell, eg: to check IsClosed.
+func OnExit(fn func(err error)) func(*Shell) error {
+	return func(s *Shell) error {
+		s.onExit = fn
+		return nil
+	}
+}
+
+// exitInfo records how a PowerShell process exited, each process gets it's
+// own which is finished exactly once by exit or kill.
+type exitInfo struct {
+	done     chan struct{}
+	err      error
+	graceful bool
+	once     sync.Once
+}
+
+func (i *exitInfo) finish(graceful bool, err error) {
+	if i == nil {
+		return
+	}
+	i.once.Do(func() {
+		i.graceful = graceful
+		i.err = err
+		close(i.done)
+	})
+}
+
+// monitor waits for the process to exit & calls the OnExit function. If the
+// process closes STDOUT without anyone noticing, ie: it died between
+// commands, it is cleaned up here.
+func (s *Shell) monitor(info *exitInfo, stdout *stream) {
+	select {
+	case <-info.done:
+	case <-stdout.eof:
+		s.mu.Lock()
+		if s.backend != nil && s.exitInfo == info {
+			if s.autoRestart {
+				s.restart()
+			} else {
+				s.kill()
+			}
+		}
+		s.mu.Unlock()
+		<-info.done
+	}
+
+	if !info.graceful {
+		s.onExit(info.err)
+	}
+}
@@ -0,0 +1,117 @@
+package gopwsh
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// Start executes a command in the background & returns readers for it's
+// output, rather than buffering it like Execute, so large amounts of output
+// can be processed incrementally or copied straight into another io.Writer.
+//
+// The readers return io.EOF once the command has completed & all of it's
+// output has been read. done receives the same error Execute would return,
+// nil on success, once the command has completed.
+//
+// Both readers must be read until io.EOF, otherwise the command will never
+// complete, use io.Copy(io.Discard, stderr) if you are not interested in one
+// of them. Just like Execute, other commands wait for this one to complete.
+//
+// e.g:
+//
+//	stdout, stderr, done := shell.Start("Get-Content big.log")
+//	go io.Copy(io.Discard, stderr)
+//	io.Copy(os.Stdout, stdout)
+//	if err := <-done; err != nil {
+//		panic(err)
+//	}
+func (s *Shell) Start(cmd string) (stdout, stderr io.Reader, done <-chan error) {
+	outR, outW := io.Pipe()
+	errR, errW := io.Pipe()
+	result := make(chan error, 1)
+
+	go func() {
+		err := s.run(context.Background(), cmd,
+			func(line string) { outW.Write([]byte(line)) },
+			func(line string) { errW.Write([]byte(line)) },
+		)
+		if err != nil {
+			err = goerr.Wrap(err, "failed to execute", cmd)
+		}
+
+		// CloseWithError(nil) is the same as Close, the readers see io.EOF
+		outW.CloseWithError(err)
+		errW.CloseWithError(err)
+		result <- err
+	}()
+
+	return outR, errR, result
+}
+
+// pipeChunkSize is how much of the reader Pipe sends with each command, once
+// base64 encoded each command is a 64KB line.
+const pipeChunkSize = 48 * 1024
+
+// Pipe executes a command with the contents of r as it's pipeline input, one
+// line at a time, eg: Pipe(file, "Set-Content out.txt") is the same as
+// Get-Content in.txt | Set-Content out.txt. So cmd must be something that can
+// follow a "|". The contents must be UTF-8 text.
+//
+// r is copied, in chunks, into a temporary file by the PowerShell process &
+// then read from there so large readers are never held in memory. Each chunk
+// is base64 encoded so it can't be corrupted by, or interfere with, the text
+// protocol used to talk to PowerShell. The temporary file is always deleted.
+//
+// Unlike ExecuteWithInput, which embeds the input into the command, this
+// takes a round trip per chunk plus two more, so prefer ExecuteWithInput
+// for small amounts of input. Only the command that reads the temporary file
+// is recorded by History & passed to a Logger.
+func (s *Shell) Pipe(r io.Reader, cmd string) (string, string, error) {
+	ctx := quiet(context.Background())
+
+	tmp, _, err := s.execute(ctx, "[System.IO.Path]::GetTempFileName()")
+	if err != nil {
+		return "", "", goerr.Wrap(err, "failed to create a temporary file for", cmd)
+	}
+	tmp = QuoteArg(strings.TrimRight(tmp, "\r\n"))
+
+	buf := make([]byte, pipeChunkSize)
+	for {
+		n, rerr := io.ReadFull(r, buf)
+		if n > 0 {
+			chunk := "& { $b = [Convert]::FromBase64String('" + base64.StdEncoding.EncodeToString(buf[:n]) + "'); " +
+				"$f = [System.IO.File]::Open(" + tmp + ", [System.IO.FileMode]::Append); " +
+				"try { $f.Write($b, 0, $b.Length) } finally { $f.Close() } }"
+			if _, _, err := s.execute(ctx, chunk); err != nil {
+				s.execute(ctx, "Remove-Item -LiteralPath "+tmp+" -Force")
+				return "", "", goerr.Wrap(err, "failed to pipe input into", cmd)
+			}
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			s.execute(ctx, "Remove-Item -LiteralPath "+tmp+" -Force")
+			return "", "", goerr.Wrap(rerr, "failed to read the input for", cmd)
+		}
+	}
+
+	// On it's own line so that a trailing comment can't swallow the "}"
+	stdout, stderr, err := s.execute(context.Background(), "try { Get-Content -LiteralPath "+tmp+" -Encoding UTF8 | "+cmd+s.lineEnding+
+		"} finally { Remove-Item -LiteralPath "+tmp+" -Force }")
+	if err != nil {
+		return stdout, stderr, goerr.Wrap(err, "failed to execute with piped input", cmd)
+	}
+	return stdout, stderr, nil
+}
+
+// MustPipe is the same as Pipe but panics on error instead of returning an error.
+func (s *Shell) MustPipe(r io.Reader, cmd string) (string, string) {
+	stdout, stderr, err := s.Pipe(r, cmd)
+	goerr.Check(err)
+	return stdout, stderr
+}
@@ -0,0 +1,158 @@
+package gopwsh
+
+import (
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// ScriptBlock is PowerShell code that a Pipeline writes as is, inside braces,
+// rather than quoting it like every other value, eg: for Where-Object.
+type ScriptBlock string
+
+// Pipeline builds a PowerShell pipeline, with every command name & value
+// quoted, so that it is safe to execute no matter what the values contain.
+//
+// Create new instances with the Shell's NewPipeline method, so that script
+// blocks use the same line ending as the commands sent to the Shell.
+//
+// e.g:
+//
+//	p := shell.NewPipeline().
+//		Command("Get-Process").Param("Name", name).
+//		Pipe("Where-Object", gopwsh.ScriptBlock("$_.CPU -gt 100")).
+//		Pipe("Select-Object").Param("First", 5)
+//	stdout, stderr, err := shell.Execute(p.String())
+type Pipeline struct {
+	err        error
+	lineEnding string
+	stages     [][]string
+}
+
+// NewPipeline is a constructor like function for the Pipeline struct.
+//
+// Script blocks use the line ending of the OS this was compiled for, see the
+// Shell's NewPipeline method for one that uses the Shell's line ending.
+func NewPipeline() *Pipeline {
+	return &Pipeline{lineEnding: newLine}
+}
+
+// NewPipeline is the same as gopwsh.NewPipeline but script blocks use the
+// Shell's line ending, see LineEnding.
+func (s *Shell) NewPipeline() *Pipeline {
+	return &Pipeline{lineEnding: s.lineEnding}
+}
+
+// Command adds a command, optionally with positional arguments, to the end of
+// the pipeline. Each argument is converted with ToPSLiteral.
+func (p *Pipeline) Command(name string, args ...interface{}) *Pipeline {
+	p.stages = append(p.stages, []string{"& " + QuoteArg(name)})
+	for _, arg := range args {
+		p.Arg(arg)
+	}
+	return p
+}
+
+// Pipe is the same as Command, it just reads better for every command after
+// the first.
+func (p *Pipeline) Pipe(name string, args ...interface{}) *Pipeline {
+	return p.Command(name, args...)
+}
+
+// Arg adds a positional argument to the last command.
+func (p *Pipeline) Arg(value interface{}) *Pipeline {
+	literal, err := p.literal(value)
+	if err != nil {
+		p.fail(goerr.Wrap(err, "failed to convert argument"))
+		return p
+	}
+	return p.add(literal)
+}
+
+// Param adds a named parameter to the last command, eg: -Name 'value'.
+//
+// The value is joined to the name with a colon, eg: -Recurse:$false, so that
+// switch parameters can be given a bool too.
+func (p *Pipeline) Param(name string, value interface{}) *Pipeline {
+	if !variableName.MatchString(name) {
+		p.fail(goerr.Wrap("invalid parameter name", name))
+		return p
+	}
+	literal, err := p.literal(value)
+	if err != nil {
+		p.fail(goerr.Wrap(err, "failed to convert parameter", name))
+		return p
+	}
+	return p.add("-" + name + ":" + literal)
+}
+
+// Switch adds a switch parameter to the last command, eg: -Recurse.
+func (p *Pipeline) Switch(name string) *Pipeline {
+	if !variableName.MatchString(name) {
+		p.fail(goerr.Wrap("invalid parameter name", name))
+		return p
+	}
+	return p.add("-" + name)
+}
+
+// Build returns the pipeline or the first error encountered while building it,
+// eg: a value that ToPSLiteral can't convert or an invalid parameter name.
+func (p *Pipeline) Build() (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
+	if len(p.stages) == 0 {
+		return "", goerr.New("the pipeline has no commands")
+	}
+
+	stages := make([]string, 0, len(p.stages))
+	for _, stage := range p.stages {
+		stages = append(stages, strings.Join(stage, " "))
+	}
+	return strings.Join(stages, " | "), nil
+}
+
+// MustBuild is the same as Build but panics on error instead of returning an error.
+func (p *Pipeline) MustBuild() string {
+	pipeline, err := p.Build()
+	goerr.Check(err)
+	return pipeline
+}
+
+// String returns the pipeline, the same as Build. When the pipeline could not
+// be built it returns a command that throws the error instead, so that a
+// partially built pipeline is never executed.
+func (p *Pipeline) String() string {
+	pipeline, err := p.Build()
+	if err != nil {
+		return "throw " + QuoteArg(err.Error())
+	}
+	return pipeline
+}
+
+func (p *Pipeline) literal(value interface{}) (string, error) {
+	if sb, ok := value.(ScriptBlock); ok {
+		// On their own lines so that a trailing comment can't swallow the "}"
+		lineEnding := p.lineEnding
+		if lineEnding == "" {
+			lineEnding = newLine
+		}
+		return "{" + lineEnding + string(sb) + lineEnding + "}", nil
+	}
+	return ToPSLiteral(value)
+}
+
+func (p *Pipeline) add(token string) *Pipeline {
+	if len(p.stages) == 0 {
+		p.fail(goerr.New("a command must be added before any arguments or parameters"))
+		return p
+	}
+	p.stages[len(p.stages)-1] = append(p.stages[len(p.stages)-1], token)
+	return p
+}
+
+func (p *Pipeline) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
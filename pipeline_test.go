@@ -0,0 +1,59 @@
+package gopwsh
+
+import (
+	"testing"
+
+	"github.com/brad-jones/gopwsh/backend"
+)
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline *Pipeline
+		want     string
+	}{
+		{
+			"command",
+			NewPipeline().Command("Get-Process", "pwsh"),
+			"& 'Get-Process' 'pwsh'",
+		},
+		{
+			"parameters & switches",
+			NewPipeline().Command("Get-ChildItem").Param("Path", `C:\it's`).Param("Depth", 2).Switch("Recurse"),
+			`& 'Get-ChildItem' -Path:'C:\it''s' -Depth:2 -Recurse`,
+		},
+		{
+			"pipe",
+			NewPipeline().Command("Get-Process").Pipe("Select-Object").Param("First", 5),
+			"& 'Get-Process' | & 'Select-Object' -First:5",
+		},
+		{
+			"script block",
+			NewPipeline().Command("Get-Process").Pipe("Where-Object", ScriptBlock("$_.CPU -gt 100 # busy")),
+			"& 'Get-Process' | & 'Where-Object' {" + newLine + "$_.CPU -gt 100 # busy" + newLine + "}",
+		},
+		{
+			"invalid parameter name",
+			NewPipeline().Command("Get-Process").Param("Name; rm", "x"),
+			"throw 'Name; rm: invalid parameter name'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pipeline.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShellNewPipeline(t *testing.T) {
+	for _, lineEnding := range []string{"\n", "\r\n"} {
+		s := newMockShell(t, backend.NewMock(), LineEnding(lineEnding))
+		got := s.NewPipeline().Command("Where-Object", ScriptBlock("$_ # comment")).String()
+		if want := "& 'Where-Object' {" + lineEnding + "$_ # comment" + lineEnding + "}"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
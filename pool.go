@@ -0,0 +1,151 @@
+package gopwsh
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// Pool keeps a number of warm shells ready for use, to avoid paying the cost
+// of starting a new PowerShell process for every unit of work.
+//
+// Shells are checked out with Get & must be returned with Put once finished.
+// On the way back in each shell is Reset, see Reset for exactly which state
+// is & isn't kept between uses.
+//
+// A Pool is safe for concurrent use by multiple goroutines.
+//
+// Create new instances of this with the "NewPool()" function.
+type Pool struct {
+	closed     bool
+	decorators []func(*Shell) error
+	mu         sync.Mutex
+	slots      chan *Shell
+}
+
+// NewPool starts size shells, with the given decorators, and returns a Pool
+// containing them. If any of the shells fail to start, those already started
+// are closed & the error is returned.
+//
+// Every shell is created with the same decorators, keep in mind that the
+// Backend decorator gives every shell the very same backend instance. The
+// backends in this module can only run a single process at a time so when
+// using a Pool either leave the backend unset, to use the Local backend, or
+// use BackendFunc.
+func NewPool(size int, decorators ...func(*Shell) error) (p *Pool, err error) {
+	defer goerr.Handle(func(e error) { p = nil; err = e })
+
+	if size < 1 {
+		goerr.Check(goerr.New(fmt.Sprintf("Pool size must be greater than 0, got %d", size)))
+	}
+
+	p = &Pool{
+		decorators: decorators,
+		slots:      make(chan *Shell, size),
+	}
+
+	for i := 0; i < size; i++ {
+		s, err := New(p.decorators...)
+		if err != nil {
+			p.Close()
+			goerr.Check(err, "Failed to fill the pool")
+		}
+		p.slots <- s
+	}
+
+	return
+}
+
+// MustNewPool is the same as NewPool but panics on error instead of returning an error.
+func MustNewPool(size int, decorators ...func(*Shell) error) *Pool {
+	p, err := NewPool(size, decorators...)
+	goerr.Check(err)
+	return p
+}
+
+// Get checks out a shell, waiting for one to be returned if they are all in
+// use. Each shell is pinged first, one that is no longer responding is
+// replaced with a new shell.
+func (p *Pool) Get(ctx context.Context) (*Shell, error) {
+	select {
+	case <-ctx.Done():
+		return nil, goerr.Wrap(ctx.Err(), "Failed to get a shell from the pool")
+
+	case s, ok := <-p.slots:
+		if !ok {
+			return nil, goerr.New("Cannot get shells from a closed pool")
+		}
+
+		if s != nil {
+			if err := s.Ping(); err == nil {
+				return s, nil
+			}
+			s.Exit()
+		}
+
+		// The slot is kept even if we can't fill it, so the next Get tries again
+		s, err := New(p.decorators...)
+		if err != nil {
+			p.putSlot(nil)
+			return nil, goerr.Wrap(err, "Failed to replace a dead shell")
+		}
+		return s, nil
+	}
+}
+
+// MustGet is the same as Get but panics on error instead of returning an error.
+func (p *Pool) MustGet(ctx context.Context) *Shell {
+	s, err := p.Get(ctx)
+	goerr.Check(err)
+	return s
+}
+
+// Put resets a shell & returns it to the pool. Shells that fail to reset are
+// closed & replaced the next time Get needs them.
+//
+// Only shells that were checked out with Get can be returned & each of them
+// must be returned exactly once, even if it has been closed.
+func (p *Pool) Put(s *Shell) {
+	if s == nil {
+		return
+	}
+	if err := s.Reset(); err != nil {
+		s.Exit()
+		s = nil
+	}
+	p.putSlot(s)
+}
+
+// Close exits every shell that is in the pool, any shells that are checked
+// out are exited when they are returned.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.slots)
+	p.mu.Unlock()
+
+	for s := range p.slots {
+		if s != nil {
+			s.Exit()
+		}
+	}
+}
+
+func (p *Pool) putSlot(s *Shell) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		if s != nil {
+			s.Exit()
+		}
+		return
+	}
+	p.slots <- s
+}
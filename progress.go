@@ -0,0 +1,101 @@
+package gopwsh
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// progressWrapper runs the command in a nested pipeline, in the current
+// runspace, so that it's progress records can be seen as they are written.
+//
+// The progress stream can not be redirected like the other streams, see
+// about_Redirection, so each record is written to STDOUT by an event handler
+// on it's own line, prefixed with a tag, the percentage & the base64 encoded
+// activity. Errors & warnings are written again once the command completes.
+const progressWrapper = "$gopwshPS = [PowerShell]::Create([System.Management.Automation.RunspaceMode]::CurrentRunspace); " +
+	"try { " +
+	"$null = $gopwshPS.AddScript(%[2]s); " +
+	"$gopwshPS.Streams.Progress.add_DataAdded({ param($c, $e) $r = $c[$e.Index]; " +
+	"[Console]::Out.WriteLine(%[1]s + ' ' + $r.PercentComplete + ' ' + [Convert]::ToBase64String([Text.Encoding]::UTF8.GetBytes($r.Activity))) }); " +
+	"$gopwshPS.Invoke() | Out-Default; " +
+	"$gopwshPS.Streams.Warning | ForEach-Object { Write-Warning $_.Message }; " +
+	"$gopwshPS.Streams.Error | ForEach-Object { Write-Error -ErrorRecord $_ } " +
+	"} finally { $gopwshPS.Dispose(); Remove-Variable gopwshPS }"
+
+// ExecuteWithProgress is like Execute but calls onProgress with the activity &
+// percentage of every progress record, eg: from Write-Progress, Copy-Item or
+// Invoke-WebRequest, as soon as it is written. percent is -1 when the record
+// does not have a percentage.
+//
+// The command runs in a nested pipeline, in the same session, so that the
+// progress records can be seen, which has a few side effects:
+//
+//   - the output is only formatted once the command has completed
+//   - errors & warnings are written once the command has completed
+//   - the Verbose, Debug & Information streams, including Write-Host, are lost
+//
+// e.g:
+//
+//	shell.ExecuteWithProgress("Copy-Item big.iso D:\\", func(activity string, percent int) {
+//		fmt.Printf("%s %d%%\n", activity, percent)
+//	})
+func (s *Shell) ExecuteWithProgress(cmd string, onProgress func(activity string, percent int)) (string, string, error) {
+	tag, err := s.newBoundary()
+	if err != nil {
+		return "", "", goerr.Wrap(err, "failed to execute", cmd)
+	}
+
+	var stdout, stderr strings.Builder
+	err = s.run(context.Background(), fmt.Sprintf(progressWrapper, QuoteArg(tag), QuoteArg(cmd)),
+		func(line string) {
+			if activity, percent, ok := parseProgress(tag, line); ok {
+				if onProgress != nil {
+					onProgress(activity, percent)
+				}
+				return
+			}
+			stdout.WriteString(line)
+		},
+		func(line string) { stderr.WriteString(line) },
+	)
+	if err != nil {
+		return stdout.String(), stderr.String(), goerr.Wrap(err, "failed to execute", cmd)
+	}
+	return stdout.String(), stderr.String(), nil
+}
+
+// MustExecuteWithProgress is the same as ExecuteWithProgress but panics on error instead of returning an error.
+func (s *Shell) MustExecuteWithProgress(cmd string, onProgress func(activity string, percent int)) (string, string) {
+	stdout, stderr, err := s.ExecuteWithProgress(cmd, onProgress)
+	goerr.Check(err)
+	return stdout, stderr
+}
+
+// parseProgress parses a line written by the progressWrapper.
+func parseProgress(tag, line string) (string, int, bool) {
+	if !strings.HasPrefix(line, tag+" ") {
+		return "", 0, false
+	}
+
+	parts := strings.SplitN(strings.TrimRight(strings.TrimPrefix(line, tag+" "), "\r\n"), " ", 2)
+	if len(parts) != 2 {
+		return "", 0, false
+	}
+
+	percent, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return "", 0, false
+	}
+
+	activity, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", 0, false
+	}
+
+	return string(activity), percent, true
+}
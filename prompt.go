@@ -0,0 +1,66 @@
+package gopwsh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// ErrNoOutput is returned, wrapped, when a command is aborted by PromptTimeout,
+// test for it with errors.Is.
+var ErrNoOutput = errors.New("no output received, possibly waiting at an interactive prompt")
+
+// PromptTimeout aborts a command when nothing at all arrives on either STDOUT
+// or STDERR for the given duration, as the command is most likely waiting on
+// an interactive prompt, eg: a stray Read-Host or a native command asking for
+// a password, which would otherwise block forever. Also see NonInteractive.
+//
+// The command is stopped just like a cancelled ExecuteContext & an error that
+// wraps ErrNoOutput is returned. Keep in mind this applies to every command,
+// so make the duration longer than any command that is silent while it works.
+//
+// Defaults to 0, commands are never aborted.
+func PromptTimeout(d time.Duration) func(*Shell) error {
+	return func(s *Shell) error {
+		if d < 0 {
+			return goerr.New(fmt.Sprintf("PromptTimeout can not be negative, got %s", d))
+		}
+		s.promptTimeout = d
+		return nil
+	}
+}
+
+// watchOutput calls cancel & sets stalled once neither stream has been read
+// from for the PromptTimeout, it returns once ctx is done.
+func (s *Shell) watchOutput(ctx context.Context, cancel context.CancelFunc, stalled *int32, streams ...*stream) {
+	started := time.Now().UnixNano()
+	interval := s.promptTimeout / 10
+	if interval <= 0 {
+		interval = s.promptTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			last := started
+			for _, st := range streams {
+				if t := atomic.LoadInt64(&st.lastRead); t > last {
+					last = t
+				}
+			}
+			if now.UnixNano()-last >= int64(s.promptTimeout) {
+				atomic.StoreInt32(stalled, 1)
+				cancel()
+				return
+			}
+		}
+	}
+}
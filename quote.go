@@ -0,0 +1,96 @@
+package gopwsh
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// QuoteArg can be used to escape string literals that you want to ensure
+// don't get mangled between your Go code and PowerShell.
+//
+// Strings that contain control characters, such as new lines, are quoted
+// with QuoteArgDouble instead so that the command stays on a single line.
+func QuoteArg(s string) string {
+	if strings.IndexFunc(s, unicode.IsControl) != -1 {
+		return QuoteArgDouble(s)
+	}
+	return "'" + singleQuoteEscaper.Replace(s) + "'"
+}
+
+// singleQuoteEscaper doubles every quote that ends a PowerShell single quoted
+// string, which includes the "smart" single quotes.
+var singleQuoteEscaper = strings.NewReplacer(
+	"'", "''",
+	"\u2018", "\u2018\u2018",
+	"\u2019", "\u2019\u2019",
+	"\u201A", "\u201A\u201A",
+	"\u201B", "\u201B\u201B",
+)
+
+// doubleQuoteEscaper escapes everything that has special meaning inside a
+// PowerShell double quoted string. PowerShell also treats the "smart" double
+// quotes as regular double quotes so they need escaping too.
+var doubleQuoteEscaper = strings.NewReplacer(
+	"`", "``",
+	"$", "`$",
+	`"`, "`\"",
+	"“", "`“",
+	"”", "`”",
+	"„", "`„",
+	"\x00", "`0",
+	"\a", "`a",
+	"\b", "`b",
+	"\f", "`f",
+	"\n", "`n",
+	"\r", "`r",
+	"\t", "`t",
+	"\v", "`v",
+)
+
+// QuoteArgDouble is like QuoteArg but produces a PowerShell double quoted
+// string, escaping "$", the double quote & the backtick with a backtick.
+//
+// Control characters such as new lines & tabs are converted to their backtick
+// escape sequences, eg: "`n", so the string always fits on a single line.
+// Those without one are written as a sub-expression, eg: $([char]0x1B).
+func QuoteArgDouble(s string) string {
+	escaped := doubleQuoteEscaper.Replace(s)
+	if strings.IndexFunc(escaped, unicode.IsControl) != -1 {
+		var sb strings.Builder
+		for _, r := range escaped {
+			if unicode.IsControl(r) {
+				sb.WriteString(fmt.Sprintf("$([char]0x%X)", r))
+				continue
+			}
+			sb.WriteRune(r)
+		}
+		escaped = sb.String()
+	}
+	return `"` + escaped + `"`
+}
+
+// QuoteHereString wraps multi-line content in a single quoted here-string,
+// ie: @'...'@, within which nothing needs to be escaped.
+//
+// A single quoted here-string can not contain a line that starts with it's
+// own terminator, if the content does then a double quoted here-string is
+// used instead with "$", the double quote & the backtick escaped.
+func QuoteHereString(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+
+	if !strings.HasPrefix(s, "'@") && !strings.Contains(s, "\n'@") {
+		return "@'\n" + s + "\n'@"
+	}
+
+	escaped := strings.NewReplacer(
+		"`", "``",
+		"$", "`$",
+		`"`, "`\"",
+		"“", "`“",
+		"”", "`”",
+		"„", "`„",
+	).Replace(s)
+
+	return "@\"\n" + escaped + "\n\"@"
+}
@@ -0,0 +1,58 @@
+package gopwsh
+
+import "testing"
+
+func TestQuoteArg(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", "''"},
+		{"plain", "foo bar", "'foo bar'"},
+		{"variable", "$foo", "'$foo'"},
+		{"single quote", "it's", "'it''s'"},
+		{"left single quote", "it‘s", "'it‘‘s'"},
+		{"right single quote", "it’s", "'it’’s'"},
+		{"low single quote", "it‚s", "'it‚‚s'"},
+		{"reversed single quote", "it‛s", "'it‛‛s'"},
+		{"double quote", `say "hi"`, `'say "hi"'`},
+		{"non-ASCII", "héllo 🌍", "'héllo 🌍'"},
+		{"new line", "line1\nline2", "\"line1`nline2\""},
+		{"crlf & tab", "a\r\n\tb", "\"a`r`n`tb\""},
+		{"control & quote", "it's\n$x", "\"it's`n`$x\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := QuoteArg(tt.in); got != tt.want {
+				t.Errorf("QuoteArg(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuoteArgDouble(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", `""`},
+		{"plain", "foo bar", `"foo bar"`},
+		{"variable", "$foo", "\"`$foo\""},
+		{"backtick", "a`b", "\"a``b\""},
+		{"double quote", `say "hi"`, "\"say `\"hi`\"\""},
+		{"smart double quotes", "“hi” „", "\"`“hi`” `„\""},
+		{"escape sequences", "\x00\a\b\f\n\r\t\v", "\"`0`a`b`f`n`r`t`v\""},
+		{"other control characters", "\x1b[31mred\x7f", "\"$([char]0x1B)[31mred$([char]0x7F)\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := QuoteArgDouble(tt.in); got != tt.want {
+				t.Errorf("QuoteArgDouble(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
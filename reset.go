@@ -0,0 +1,72 @@
+package gopwsh
+
+import (
+	"context"
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// snapshotCmd records the names of the global variables that exist once the
+// session has been initialised & outputs the current location.
+const snapshotCmd = "$global:gopwshVariables = @(Get-Variable -Scope Global | ForEach-Object Name) + 'gopwshVariables'; (Get-Location).Path"
+
+// snapshot records the state that Reset returns the session to, it must be
+// called with the lock held.
+func (s *Shell) snapshot() error {
+	var location strings.Builder
+	_, err := s.runLocked(context.Background(), snapshotCmd,
+		func(line string) { location.WriteString(line) },
+		func(string) {},
+	)
+	if err != nil {
+		return goerr.Wrap(err, "Failed to record the initial state of the session")
+	}
+	s.initialDir = strings.TrimRight(location.String(), "\r\n")
+	return nil
+}
+
+// Reset returns the PowerShell session to the state it was in when it was
+// first started, without the cost of starting a new process. This is done
+// in a single command:
+//
+//   - global variables that did not exist at startup are removed
+//   - $ErrorActionPreference is reset & $Error is cleared
+//   - the location is returned to the initial working directory
+//   - the preferences set by options such as ErrorActionStop, OutputEncoding
+//     and ProgressPreference are set again
+//
+// Startup commands that must only run once, such as those of Transcript,
+// ModulePath & Prelude, are not run again. Anything else is kept, eg:
+// imported modules, functions, aliases, drives, changes made to variables
+// that did exist at startup, environment variables, background jobs & the
+// $LASTEXITCODE of the command before Reset.
+func (s *Shell) Reset() error {
+	cmd := "Get-Variable -Scope Global | " +
+		"Where-Object { $gopwshVariables -notcontains $_.Name } | " +
+		"Remove-Variable -Scope Global -Force -ErrorAction SilentlyContinue; " +
+		"$global:ErrorActionPreference = 'Continue'; " +
+		"$global:Error.Clear()"
+
+	s.mu.Lock()
+	initialDir := s.initialDir
+	preferences := s.preferenceCmds()
+	s.mu.Unlock()
+
+	if initialDir != "" {
+		cmd = cmd + "; Set-Location -LiteralPath " + QuoteArg(initialDir)
+	}
+	for _, c := range preferences {
+		cmd = cmd + "; " + c
+	}
+
+	if _, _, err := s.execute(context.Background(), cmd); err != nil {
+		return goerr.Wrap(err, "failed to reset the session")
+	}
+	return nil
+}
+
+// MustReset is the same as Reset but panics on error instead of returning an error.
+func (s *Shell) MustReset() {
+	goerr.Check(s.Reset())
+}
@@ -0,0 +1,79 @@
+package gopwsh
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// RetryOpts configures ExecuteRetry.
+type RetryOpts struct {
+	// MaxAttempts is the total number of times the command is executed,
+	// including the first. Defaults to 3.
+	MaxAttempts int
+
+	// Backoff is how long to wait before the first retry, the wait is doubled
+	// before each retry after that. Defaults to no wait at all.
+	Backoff time.Duration
+
+	// MaxBackoff caps the doubling of Backoff, 0 means no cap.
+	MaxBackoff time.Duration
+
+	// ShouldRetry decides if an attempt failed & should be retried, it is
+	// given the result of the attempt. Defaults to retrying when err is not
+	// nil, except for a ParserError which would fail every time. If the last
+	// attempt should still be retried an error is returned, even if err is nil.
+	ShouldRetry func(stdout, stderr string, err error) bool
+}
+
+// ExecuteRetry is like Execute but executes the command again when it fails,
+// eg: for commands that use a flaky network resource. The result of the last
+// attempt is returned.
+//
+// All attempts are executed by this Shell, so it gives up early if the Shell
+// has been closed, eg: when it was killed because of a ParserError.
+func (s *Shell) ExecuteRetry(opts RetryOpts, cmd string) (stdout, stderr string, err error) {
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 3
+	}
+	if opts.ShouldRetry == nil {
+		opts.ShouldRetry = func(stdout, stderr string, err error) bool {
+			var parserErr *ParserError
+			return err != nil && !errors.As(err, &parserErr)
+		}
+	}
+
+	backoff := opts.Backoff
+	for attempt := 1; ; attempt++ {
+		stdout, stderr, err = s.execute(context.Background(), cmd)
+		if !opts.ShouldRetry(stdout, stderr, err) {
+			break
+		}
+		if attempt == opts.MaxAttempts || s.IsClosed() {
+			if err == nil {
+				err = goerr.New("The command did not succeed")
+			}
+			return stdout, stderr, goerr.Wrap(err, "failed to execute after retrying", cmd)
+		}
+
+		time.Sleep(backoff)
+		backoff = backoff * 2
+		if opts.MaxBackoff > 0 && backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+
+	if err != nil {
+		return stdout, stderr, goerr.Wrap(err, "failed to execute", cmd)
+	}
+	return stdout, stderr, nil
+}
+
+// MustExecuteRetry is the same as ExecuteRetry but panics on error instead of returning an error.
+func (s *Shell) MustExecuteRetry(opts RetryOpts, cmd string) (string, string) {
+	stdout, stderr, err := s.ExecuteRetry(opts, cmd)
+	goerr.Check(err)
+	return stdout, stderr
+}
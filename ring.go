@@ -0,0 +1,58 @@
+package gopwsh
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// StderrRingBuffer keeps the last n bytes written to PowerShell's STDERR, see
+// LastStderr. Useful to find out why a process died, even during startup.
+//
+// Everything is kept, not just the output of commands, & the buffer lives for
+// as long as the Shell so it is still available after Exit or a restart.
+func StderrRingBuffer(n int) func(*Shell) error {
+	return func(s *Shell) error {
+		if n < 1 {
+			return goerr.New(fmt.Sprintf("StderrRingBuffer must be greater than 0, got %d", n))
+		}
+		s.stderrRing = &ringBuffer{size: n}
+		return nil
+	}
+}
+
+// LastStderr returns the most recent bytes written to PowerShell's STDERR, up
+// to the size given to StderrRingBuffer. Returns an empty string when that
+// option was not used.
+func (s *Shell) LastStderr() string {
+	if s.stderrRing == nil {
+		return ""
+	}
+	return s.stderrRing.String()
+}
+
+// ringBuffer is an io.Writer that only keeps the last size bytes written to it.
+type ringBuffer struct {
+	buf  []byte
+	mu   sync.Mutex
+	size int
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buf = append(r.buf, p...)
+	if over := len(r.buf) - r.size; over > 0 {
+		copy(r.buf, r.buf[over:])
+		r.buf = r.buf[:r.size]
+	}
+	return len(p), nil
+}
+
+func (r *ringBuffer) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return string(r.buf)
+}
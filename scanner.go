@@ -0,0 +1,116 @@
+package gopwsh
+
+import (
+	"context"
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// Scanner reads the output of a command executed by ExecuteScanner one line
+// at a time, as it arrives, much like a bufio.Scanner.
+//
+// Lines from STDOUT & STDERR are both returned, in the order they were read,
+// use Stderr to tell them apart. A Scanner must not be used by more than one
+// goroutine at a time.
+type Scanner struct {
+	done  chan error
+	err   error
+	line  scannedLine
+	lines chan scannedLine
+}
+
+type scannedLine struct {
+	stderr bool
+	text   string
+}
+
+// scannerBuffer is how many lines can be read ahead of the caller before
+// reading from PowerShell is paused.
+const scannerBuffer = 64
+
+// ExecuteScanner executes a command in the background & returns a Scanner for
+// it's output, so that large amounts of output can be processed line by line
+// without ever being buffered in full.
+//
+// The Scanner must be read until Scan returns false, or closed, otherwise the
+// command will never complete. Just like Execute, other commands wait for this
+// one to complete.
+//
+// e.g:
+//
+//	scanner := shell.ExecuteScanner("Get-Content big.log")
+//	for scanner.Scan() {
+//		if !scanner.Stderr() {
+//			fmt.Println(scanner.Text())
+//		}
+//	}
+//	if err := scanner.Err(); err != nil {
+//		panic(err)
+//	}
+func (s *Shell) ExecuteScanner(cmd string) *Scanner {
+	sc := &Scanner{
+		done:  make(chan error, 1),
+		lines: make(chan scannedLine, scannerBuffer),
+	}
+
+	go func() {
+		err := s.run(context.Background(), cmd,
+			func(line string) { sc.lines <- scannedLine{text: line} },
+			func(line string) { sc.lines <- scannedLine{stderr: true, text: line} },
+		)
+		if err != nil {
+			err = goerr.Wrap(err, "failed to execute", cmd)
+		}
+		sc.done <- err
+		close(sc.lines)
+	}()
+
+	return sc
+}
+
+// Scan advances to the next line, which is then available from Text. It
+// returns false once the command has completed & all of it's output has been
+// read, see Err.
+func (sc *Scanner) Scan() bool {
+	line, ok := <-sc.lines
+	if !ok {
+		sc.finish()
+		return false
+	}
+	sc.line = line
+	return true
+}
+
+// Text returns the current line, without it's line ending.
+func (sc *Scanner) Text() string {
+	return strings.TrimRight(sc.line.text, "\r\n")
+}
+
+// Stderr reports whether the current line was written to STDERR.
+func (sc *Scanner) Stderr() bool {
+	return sc.line.stderr
+}
+
+// Err returns the same error Execute would have returned, once Scan has
+// returned false, nil on success.
+func (sc *Scanner) Err() error {
+	return sc.err
+}
+
+// Close discards any output that has not been read yet, waits for the command
+// to complete & returns the same error as Err.
+func (sc *Scanner) Close() error {
+	for range sc.lines {
+	}
+	sc.finish()
+	return sc.err
+}
+
+func (sc *Scanner) finish() {
+	sc.line = scannedLine{}
+	if sc.done != nil {
+		sc.err = <-sc.done
+		sc.done = nil
+	}
+}
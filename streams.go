@@ -0,0 +1,183 @@
+package gopwsh
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// Result holds the output of a command split up by PowerShell stream.
+//
+// see: https://docs.microsoft.com/en-us/powershell/module/microsoft.powershell.core/about/about_output_streams
+type Result struct {
+	// Output is the formatted success stream, ie: what you see in a console.
+	Output string
+
+	// Error contains any error records along with anything else that was
+	// written directly to the process's STDERR.
+	Error string
+
+	Warning     string
+	Verbose     string
+	Debug       string
+	Information string
+}
+
+// streamsWrapper redirects all streams into a ForEach-Object which writes each
+// record to STDOUT on it's own line, prefixed with a tag & the stream name.
+// The record text is base64 encoded so multi-line records stay on one line.
+//
+// The command is dot sourced so that it still runs in the current scope,
+// any variables or functions it defines will exist for later commands. It is
+// on it's own lines so that a trailing comment can't swallow the "}".
+const streamsWrapper = "$gopwshOut = [System.Collections.Generic.List[object]]::new(); " +
+	". {%[3]s%[2]s%[3]s} *>&1 | ForEach-Object { " +
+	"if ($_ -is [System.Management.Automation.ErrorRecord]) { $gopwshName = 'Error'; $gopwshValue = $_ | Out-String } " +
+	"elseif ($_ -is [System.Management.Automation.WarningRecord]) { $gopwshName = 'Warning'; $gopwshValue = $_.Message } " +
+	"elseif ($_ -is [System.Management.Automation.VerboseRecord]) { $gopwshName = 'Verbose'; $gopwshValue = $_.Message } " +
+	"elseif ($_ -is [System.Management.Automation.DebugRecord]) { $gopwshName = 'Debug'; $gopwshValue = $_.Message } " +
+	"elseif ($_ -is [System.Management.Automation.InformationRecord]) { $gopwshName = 'Information'; $gopwshValue = $_.ToString() } " +
+	"else { $gopwshOut.Add($_); return }; " +
+	"[Console]::Out.WriteLine(%[1]s + ' ' + $gopwshName + ' ' + [Convert]::ToBase64String([Text.Encoding]::UTF8.GetBytes($gopwshValue))) " +
+	"}; " +
+	"[Console]::Out.WriteLine(%[1]s + ' Output ' + [Convert]::ToBase64String([Text.Encoding]::UTF8.GetBytes(($gopwshOut | Out-String)))); " +
+	"Remove-Variable gopwshOut, gopwshName, gopwshValue -ErrorAction SilentlyContinue"
+
+// ExecuteStreams is like Execute but instead of lumping all of PowerShell's
+// output streams into STDOUT & STDERR, each stream is captured separately.
+//
+// This means you can, for example, surface warnings to a user without
+// treating them as failures.
+//
+// Keep in mind that Verbose & Debug records are only written when the
+// respective preference variables ask for them, or when the cmdlet is
+// called with -Verbose / -Debug.
+func (s *Shell) ExecuteStreams(cmd string) (*Result, error) {
+	tag, err := s.newBoundary()
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to execute", cmd)
+	}
+	stdout, stderr, err := s.execute(context.Background(), fmt.Sprintf(streamsWrapper, QuoteArg(tag), cmd, s.lineEnding))
+	result := parseStreams(tag, stdout, stderr)
+	if err != nil {
+		return result, goerr.Wrap(err, "failed to execute", cmd)
+	}
+	return result, nil
+}
+
+// MustExecuteStreams is the same as ExecuteStreams but panics on error instead of returning an error.
+func (s *Shell) MustExecuteStreams(cmd string) *Result {
+	result, err := s.ExecuteStreams(cmd)
+	goerr.Check(err)
+	return result
+}
+
+func parseStreams(tag, stdout, stderr string) *Result {
+	var untagged strings.Builder
+	streams := map[string]*strings.Builder{}
+	for _, name := range []string{"Output", "Error", "Warning", "Verbose", "Debug", "Information"} {
+		streams[name] = &strings.Builder{}
+	}
+
+	for _, line := range strings.SplitAfter(stdout, "\n") {
+		if !strings.HasPrefix(line, tag+" ") {
+			untagged.WriteString(line)
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, tag+" ")), " ", 2)
+		if len(parts) != 2 {
+			untagged.WriteString(line)
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			untagged.WriteString(line)
+			continue
+		}
+
+		stream, ok := streams[parts[0]]
+		if !ok {
+			continue
+		}
+		stream.Write(decoded)
+		if parts[0] != "Output" && parts[0] != "Error" {
+			stream.WriteString(newLine)
+		}
+	}
+
+	// Anything that bypassed the PowerShell pipeline, for example by using
+	// [Console]::WriteLine(), is treated as regular output.
+	return &Result{
+		Output:      untagged.String() + streams["Output"].String(),
+		Error:       streams["Error"].String() + stderr,
+		Warning:     streams["Warning"].String(),
+		Verbose:     streams["Verbose"].String(),
+		Debug:       streams["Debug"].String(),
+		Information: streams["Information"].String(),
+	}
+}
+
+// ExecuteCombined is like Execute but STDOUT & STDERR are merged, in the order
+// they were written, like a console would show them.
+//
+// The command's error stream is redirected into it's success stream, with
+// 2>&1, so that PowerShell does the merging & there is only a single pipe to
+// read. Anything written directly to the process's STDERR, eg: with
+// [Console]::Error, can not be redirected & is appended to the end.
+//
+// The command is dot sourced so that it still runs in the current scope.
+func (s *Shell) ExecuteCombined(cmd string) (string, error) {
+	// On their own lines so that a trailing comment can't swallow the "}"
+	wrapped := ". {" + s.lineEnding + cmd + s.lineEnding + "} 2>&1"
+
+	stdout, stderr, err := s.execute(context.Background(), wrapped)
+	if err != nil {
+		return stdout + stderr, goerr.Wrap(err, "failed to execute", cmd)
+	}
+	return stdout + stderr, nil
+}
+
+// MustExecuteCombined is the same as ExecuteCombined but panics on error instead of returning an error.
+func (s *Shell) MustExecuteCombined(cmd string) string {
+	output, err := s.ExecuteCombined(cmd)
+	goerr.Check(err)
+	return output
+}
+
+// ExecuteBytes is the same as Execute but returns the output exactly as it
+// was read from PowerShell's STDOUT & STDERR pipes, for output that is not
+// valid UTF-8, eg: a legacy code page. Nothing is decoded or normalised,
+// including line endings, unless StdoutEncoding or StderrEncoding is set.
+//
+// Keep in mind PowerShell itself writes objects as text, in it's
+// OutputEncoding, so binary data is best sent as base64, eg:
+// [Convert]::ToBase64String([IO.File]::ReadAllBytes('file.bin'))
+func (s *Shell) ExecuteBytes(cmd string) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	err := s.run(context.Background(), cmd,
+		func(line string) { stdout.WriteString(line) },
+		func(line string) { stderr.WriteString(line) },
+	)
+	if err != nil {
+		var runtimeErr *RuntimeError
+		if errors.As(err, &runtimeErr) {
+			runtimeErr.Stderr = stderr.String()
+		}
+		return stdout.Bytes(), stderr.Bytes(), goerr.Wrap(err, "failed to execute", cmd)
+	}
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
+
+// MustExecuteBytes is the same as ExecuteBytes but panics on error instead of returning an error.
+func (s *Shell) MustExecuteBytes(cmd string) ([]byte, []byte) {
+	stdout, stderr, err := s.ExecuteBytes(cmd)
+	goerr.Check(err)
+	return stdout, stderr
+}
@@ -0,0 +1,31 @@
+package gopwsh
+
+import (
+	"io"
+)
+
+// Tee mirrors the output of every command to the given writers, as it is
+// read, while it is still captured & returned as usual. eg: Tee(os.Stdout,
+// os.Stderr) to watch what PowerShell is doing.
+//
+// Either writer may be nil to only mirror one of the streams. The markers used
+// to find the end of each command are never written & errors returned by the
+// writers are ignored.
+func Tee(stdout, stderr io.Writer) func(*Shell) error {
+	return func(s *Shell) error {
+		s.teeStdout = stdout
+		s.teeStderr = stderr
+		return nil
+	}
+}
+
+// tee wraps a callback so that each line is also written to w.
+func tee(w io.Writer, fn func(string)) func(string) {
+	if w == nil {
+		return fn
+	}
+	return func(line string) {
+		io.WriteString(w, line)
+		fn(line)
+	}
+}
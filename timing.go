@@ -0,0 +1,84 @@
+package gopwsh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brad-jones/goerr/v2"
+)
+
+// TimedResult holds the output of a command executed by ExecuteTimed along
+// with when it started & completed according to PowerShell's clock.
+type TimedResult struct {
+	Stdout string
+	Stderr string
+
+	// Start & End are measured inside PowerShell, immediately before & after
+	// the command. They are zero if the command never started.
+	Start time.Time
+	End   time.Time
+
+	// RoundTrip is how long it took, measured by us, from sending the command
+	// until all of it's output had been read.
+	RoundTrip time.Duration
+}
+
+// Duration is how long the command took inside PowerShell, ie: without the
+// latency of talking to the PowerShell process.
+func (r *TimedResult) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// timedWrapper records the time before & after the command & writes both to
+// STDOUT, on a line prefixed with a tag, even when the command throws. The
+// command is on it's own lines so that a trailing comment can't swallow the
+// rest. $? is captured straight after the command & made false again, without
+// writing an error, so that a failed command is still seen as failed.
+const timedWrapper = "$gopwshStart = (Get-Date).ToString('o'); $gopwshOk = $false; try {\n" +
+	"%[2]s\n" +
+	"$gopwshOk = $? } finally { [Console]::Out.WriteLine(%[1]s + ' ' + $gopwshStart + ' ' + (Get-Date).ToString('o')) }; " +
+	"if (-not $gopwshOk) { Write-Error 'failed' -ErrorAction Ignore }"
+
+// ExecuteTimed is like Execute but also measures, inside PowerShell, when the
+// command started & completed. Comparing the Duration with the RoundTrip shows
+// how much time was spent on latency, eg: for remote backends.
+func (s *Shell) ExecuteTimed(cmd string) (*TimedResult, error) {
+	tag, err := s.newBoundary()
+	if err != nil {
+		return nil, goerr.Wrap(err, "failed to execute", cmd)
+	}
+
+	sent := time.Now()
+	stdout, stderr, err := s.execute(context.Background(), fmt.Sprintf(timedWrapper, QuoteArg(tag), cmd))
+	result := &TimedResult{Stderr: stderr, RoundTrip: time.Since(sent)}
+
+	// The line may be anywhere in STDOUT, eg: when the output is formatted
+	// as a table it is only written once the whole command has completed.
+	var output strings.Builder
+	for _, line := range strings.SplitAfter(stdout, "\n") {
+		if !strings.HasPrefix(line, tag+" ") {
+			output.WriteString(line)
+			continue
+		}
+		fields := strings.Fields(line[len(tag):])
+		if len(fields) == 2 {
+			result.Start, _ = time.Parse(time.RFC3339Nano, fields[0])
+			result.End, _ = time.Parse(time.RFC3339Nano, fields[1])
+		}
+	}
+	result.Stdout = output.String()
+
+	if err != nil {
+		return result, goerr.Wrap(err, "failed to execute", cmd)
+	}
+	return result, nil
+}
+
+// MustExecuteTimed is the same as ExecuteTimed but panics on error instead of returning an error.
+func (s *Shell) MustExecuteTimed(cmd string) *TimedResult {
+	result, err := s.ExecuteTimed(cmd)
+	goerr.Check(err)
+	return result
+}
@@ -0,0 +1,59 @@
+package gopwsh
+
+import (
+	"github.com/brad-jones/goerr/v2"
+)
+
+// PSVersion describes the PowerShell that a Shell is running.
+type PSVersion struct {
+	Major int
+	Minor int
+
+	// Edition is "Core" for PowerShell 6+ or "Desktop" for Windows PowerShell.
+	Edition string
+
+	// OS is a description of the operating system, eg: "Microsoft Windows 10.0.19044"
+	OS string
+}
+
+// versionCmd queries $PSVersionTable. Windows PowerShell before 5.1 has no
+// PSEdition & only PowerShell 6+ has OS, so those are filled in ourselves.
+const versionCmd = "[pscustomobject]@{ " +
+	"Major = $PSVersionTable.PSVersion.Major; " +
+	"Minor = $PSVersionTable.PSVersion.Minor; " +
+	"Edition = $(if ($PSVersionTable.PSEdition) { $PSVersionTable.PSEdition } else { 'Desktop' }); " +
+	"OS = $(if ($PSVersionTable.OS) { $PSVersionTable.OS } else { [Environment]::OSVersion.VersionString }) " +
+	"}"
+
+// Version returns the edition & version of the running PowerShell, this can
+// be used to branch between Windows PowerShell 5.1 & PowerShell 7+ for example.
+//
+// $PSVersionTable is only queried by the first call, the result is cached.
+func (s *Shell) Version() (*PSVersion, error) {
+	s.versionMu.Lock()
+	defer s.versionMu.Unlock()
+
+	if s.version != nil {
+		v := *s.version
+		return &v, nil
+	}
+
+	v := &PSVersion{}
+	if err := s.ExecuteJSON(versionCmd, v); err != nil {
+		return nil, goerr.Wrap(err, "failed to query $PSVersionTable")
+	}
+	if v.Major == 0 {
+		return nil, goerr.New("failed to query $PSVersionTable, no version was returned")
+	}
+
+	s.version = v
+	c := *v
+	return &c, nil
+}
+
+// MustVersion is the same as Version but panics on error instead of returning an error.
+func (s *Shell) MustVersion() *PSVersion {
+	v, err := s.Version()
+	goerr.Check(err)
+	return v
+}